@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+/*
+/api/cheatsheet/${name}.json is a machine-readable version of a cheatsheet:
+{
+  "name": "go", "title": "Go", "url": "/cheatsheet/go.html", ...front matter,
+  "toc": [{"id": "basics", "content": "Basics", "level": 2, "children": [...]}, ...],
+  "sections": [{"id": "basics", "heading": "Basics", "level": 2, "blocks": [...]}, ...]
+}
+Blocks are:
+{"type": "prose", "html": "<p>...</p>"}
+{"type": "code", "lang": "go", "code": "..."}
+{"type": "table", "header": ["..."], "rows": [["...", ...], ...]}
+Content before the first heading is in a section with empty id.
+*/
+
+type apiTocNode struct {
+	ID          string        `json:"id,omitempty"`
+	Content     string        `json:"content,omitempty"`
+	Level       int           `json:"level"`
+	Synthesized bool          `json:"synthesized,omitempty"`
+	Children    []*apiTocNode `json:"children,omitempty"`
+}
+
+type apiBlock struct {
+	Type   string     `json:"type"`
+	HTML   string     `json:"html,omitempty"`
+	Lang   string     `json:"lang,omitempty"`
+	Code   string     `json:"code,omitempty"`
+	Header []string   `json:"header,omitempty"`
+	Rows   [][]string `json:"rows,omitempty"`
+}
+
+type apiSection struct {
+	ID      string      `json:"id"`
+	Heading string      `json:"heading"`
+	Level   int         `json:"level"`
+	Blocks  []*apiBlock `json:"blocks"`
+}
+
+type apiCheatsheet struct {
+	Name        string        `json:"name"`
+	Title       string        `json:"title"`
+	URL         string        `json:"url"`
+	Category    string        `json:"category,omitempty"`
+	Tags        []string      `json:"tags,omitempty"`
+	Keywords    []string      `json:"keywords,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Intro       string        `json:"intro,omitempty"`
+	Updated     *time.Time    `json:"updated,omitempty"`
+	Toc         []*apiTocNode `json:"toc"`
+	Sections    []*apiSection `json:"sections"`
+}
+
+func csAPIURL(cs *cheatSheet) string {
+	return "/api/cheatsheet/" + cs.fileNameBase + ".json"
+}
+
+func toAPITocNodes(nodes []*tocNode) []*apiTocNode {
+	var res []*apiTocNode
+	for _, n := range nodes {
+		res = append(res, &apiTocNode{
+			ID:          n.ID,
+			Content:     n.Content,
+			Level:       n.HeadingLevel,
+			Synthesized: n.heading == nil,
+			Children:    toAPITocNodes(n.Children),
+		})
+	}
+	return res
+}
+
+func apiTableRows(table *ast.Table) (header []string, rows [][]string) {
+	ast.WalkFunc(table, func(node ast.Node, entering bool) ast.WalkStatus {
+		row, ok := node.(*ast.TableRow)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		var cells []string
+		for _, c := range row.GetChildren() {
+			cells = append(cells, strings.TrimSpace(astText(c)))
+		}
+		if _, isHeader := row.GetParent().(*ast.TableHeader); isHeader {
+			header = cells
+		} else {
+			rows = append(rows, cells)
+		}
+		return ast.SkipChildren
+	})
+	return header, rows
+}
+
+func apiBlockFromNode(node ast.Node) *apiBlock {
+	switch v := node.(type) {
+	case *ast.CodeBlock:
+		return &apiBlock{
+			Type: "code",
+			Lang: string(v.Info),
+			Code: string(v.Literal),
+		}
+	case *ast.Table:
+		header, rows := apiTableRows(v)
+		return &apiBlock{
+			Type:   "table",
+			Header: header,
+			Rows:   rows,
+		}
+	}
+	renderer := newMarkdownHTMLRenderer("")
+	html := strings.TrimSpace(string(markdown.Render(node, renderer)))
+	if html == "" {
+		return nil
+	}
+	return &apiBlock{
+		Type: "prose",
+		HTML: html,
+	}
+}
+
+// genCheatsheetJSON returns content of /api/cheatsheet/${name}.json
+// cheatsheets is used to resolve links to other cheatsheets
+func genCheatsheetJSON(cs *cheatSheet, cheatsheets []*cheatSheet) ([]byte, error) {
+	if cs.err != nil {
+		return nil, cs.err
+	}
+	doc := csParseMarkdown(cs)
+	resolveCsLinks(doc, cheatsheets)
+	toc, err := csBuildToc(doc, cs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &apiCheatsheet{
+		Name:        cs.fileNameBase,
+		Title:       cs.Title,
+		URL:         csURL(cs),
+		Category:    cs.meta.Category,
+		Tags:        cs.meta.Tags,
+		Keywords:    cs.meta.Keywords,
+		Description: cs.meta.Description,
+		Intro:       cs.meta.Intro,
+		Toc:         toAPITocNodes(toc),
+		Sections:    []*apiSection{},
+	}
+	if !cs.meta.Updated.IsZero() {
+		res.Updated = &cs.meta.Updated
+	}
+
+	// doc is ast.Document, all ast.Heading are direct children
+	var curr *apiSection
+	for _, node := range doc.GetChildren() {
+		if h, ok := node.(*ast.Heading); ok {
+			curr = &apiSection{
+				ID:      h.HeadingID,
+				Heading: astText(h),
+				Level:   h.Level,
+				Blocks:  []*apiBlock{},
+			}
+			res.Sections = append(res.Sections, curr)
+			continue
+		}
+		block := apiBlockFromNode(node)
+		if block == nil {
+			continue
+		}
+		if curr == nil {
+			curr = &apiSection{
+				Blocks: []*apiBlock{},
+			}
+			res.Sections = append(res.Sections, curr)
+		}
+		curr.Blocks = append(curr.Blocks, block)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(res)
+	return buf.Bytes(), err
+}
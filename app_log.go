@@ -0,0 +1,211 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kjk/common/filerotate"
+)
+
+// app logs, i.e. everything we log with logf() etc., are written to
+// logs/applog-${day}.txt, in addition to httplog-${day}_${hour}.txt
+// written by httplogger. When we rotate the file we compress the
+// previous one (if enabled), upload it like http logs and delete
+// logs older than appLogMaxAgeDays. Logs of previous days that were
+// not rotated because we were not running are handled at startup
+
+const (
+	appLogPrefix = "applog-"
+
+	// we keep logs for that many days and at most that many files
+	appLogMaxAgeDays = 30
+	appLogMaxFiles   = 60
+)
+
+var (
+	// protects appLog
+	appLogMu sync.Mutex
+	appLog   *filerotate.File
+
+	// if true, rotated app logs are compressed to .br. Set with -log-compress
+	appLogCompress = true
+)
+
+// appLogDay returns day of log file applog-2021-10-06.txt[.br]
+// or false if it's not an app log file
+func appLogDay(path string) (time.Time, bool) {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, appLogPrefix) {
+		return time.Time{}, false
+	}
+	name = strings.TrimPrefix(name, appLogPrefix)
+	name = strings.TrimSuffix(name, ".br")
+	name = strings.TrimSuffix(name, ".txt")
+	day, err := time.Parse("2006-01-02", name)
+	return day, err == nil
+}
+
+// upload applog-2021-10-06.txt[.br] as
+// apps/${app}/applog/2021/10-06.txt.br
+func appLogRemotePath(app string, path string) string {
+	day, ok := appLogDay(path)
+	if !ok {
+		return ""
+	}
+	return "apps/" + app + "/applog/" + day.Format("2006/01-02") + ".txt.br"
+}
+
+// compressAppLog compresses path to path.br and removes path
+// returns path of the compressed file or path if compression failed
+func compressAppLog(path string) string {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		logerrf(ctx(), "compressAppLog: '%s' failed with '%s'\n", path, err)
+		return path
+	}
+	pathBr := path + ".br"
+	err = os.WriteFile(pathBr, brotliCompress(d), 0644)
+	if err != nil {
+		logerrf(ctx(), "compressAppLog: '%s' failed with '%s'\n", pathBr, err)
+		os.Remove(pathBr)
+		return path
+	}
+	os.Remove(path)
+	return pathBr
+}
+
+// removeOldAppLogs deletes app logs older than maxAgeDays or
+// if there are more than maxFiles
+func removeOldAppLogs(dir string, maxAgeDays int, maxFiles int, now time.Time) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var paths []string
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if _, ok := appLogDay(path); ok && !e.IsDir() {
+			paths = append(paths, path)
+		}
+	}
+	// newest first
+	sort.Slice(paths, func(i, j int) bool {
+		return filepath.Base(paths[i]) > filepath.Base(paths[j])
+	})
+	cutoff := now.AddDate(0, 0, -maxAgeDays)
+	for i, path := range paths {
+		day, _ := appLogDay(path)
+		if i < maxFiles && !day.Before(cutoff) {
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil {
+			logerrf(ctx(), "removeOldAppLogs: os.Remove('%s') failed with '%s'\n", path, err)
+			continue
+		}
+		logf(ctx(), "removeOldAppLogs: removed '%s'\n", path)
+	}
+}
+
+// writeAppLog is called by logWrite()
+func writeAppLog(s string) {
+	appLogMu.Lock()
+	defer appLogMu.Unlock()
+	if appLog != nil {
+		appLog.Write([]byte(s))
+	}
+}
+
+// processRotatedAppLog compresses (if enabled) and uploads a log
+// file we no longer write to
+func processRotatedAppLog(app string, dir string, path string) {
+	if appLogCompress {
+		path = compressAppLog(path)
+	}
+	removeOldAppLogs(dir, appLogMaxAgeDays, appLogMaxFiles, time.Now())
+	if !canUploadLogs() {
+		return
+	}
+	queueLogUpload(appLogRemotePath(app, path), path)
+}
+
+// findUnprocessedAppLogs returns logs of previous days that were not
+// rotated, e.g. because we were not running at midnight
+// Without compression we can't tell if a .txt file was already
+// uploaded so we only do it if compression is enabled
+func findUnprocessedAppLogs(dir string, now time.Time) []string {
+	if !appLogCompress {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	today := appLogPrefix + now.Format("2006-01-02") + ".txt"
+	var res []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || name == today || !strings.HasSuffix(name, ".txt") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if _, ok := appLogDay(path); ok {
+			res = append(res, path)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// OpenAppLog starts writing app logs to daily files in logs directory
+func OpenAppLog(app string) func() {
+	panicIf(app == "")
+	dir := "logs"
+	must(os.MkdirAll(dir, 0755))
+	startLogUploads(dir)
+
+	onRotate := func(path string) {
+		// called from appLog.Write() with appLogMu locked so we
+		// can't log synchronously
+		go processRotatedAppLog(app, dir, path)
+	}
+	daily := func(creationTime time.Time, now time.Time) string {
+		if filerotate.IsSameDay(creationTime, now) {
+			return ""
+		}
+		name := appLogPrefix + now.Format("2006-01-02") + ".txt"
+		return filepath.Join(dir, name)
+	}
+	config := filerotate.Config{
+		DidClose: func(path string, didRotate bool) {
+			if didRotate {
+				onRotate(path)
+			}
+		},
+		PathIfShouldRotate: daily,
+	}
+	f, err := filerotate.New(&config)
+	must(err)
+	appLogMu.Lock()
+	appLog = f
+	appLogMu.Unlock()
+	removeOldAppLogs(dir, appLogMaxAgeDays, appLogMaxFiles, time.Now())
+	unprocessed := findUnprocessedAppLogs(dir, time.Now())
+	go func() {
+		for _, path := range unprocessed {
+			logf(ctx(), "OpenAppLog: processing '%s' left by previous run\n", path)
+			processRotatedAppLog(app, dir, path)
+		}
+	}()
+	logf(ctx(), "opened app log file in '%s'\n", dir)
+	return func() {
+		appLogMu.Lock()
+		appLog = nil
+		appLogMu.Unlock()
+		f.Close()
+	}
+}
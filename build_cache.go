@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/kjk/common/u"
+)
+
+// build manifest remembers hash of inputs of every generated file
+// so that generateStatic() only re-generates files whose inputs changed
+// It's stored in www_generated but not served (see runServerProd())
+const buildManifestName = ".build_manifest.json"
+
+// buildGeneratorVersion is part of hash of inputs of generated pages.
+// Bump it when changing how pages are rendered so that they are re-generated
+const buildGeneratorVersion = "1"
+
+type buildManifest struct {
+	// maps url of generated file to hash of its inputs
+	Outputs map[string]string `json:"outputs"`
+	// urls of files for which we wrote .br and .gz. We don't write
+	// them if they're not smaller so we can't just check if they exist
+	Precompressed map[string]bool `json:"precompressed,omitempty"`
+}
+
+func loadBuildManifest(path string) *buildManifest {
+	res := &buildManifest{
+		Outputs: map[string]string{},
+	}
+	d, err := os.ReadFile(path)
+	if err != nil {
+		// no manifest means a full rebuild
+		return res
+	}
+	err = json.Unmarshal(d, res)
+	if err != nil || res.Outputs == nil {
+		logWarn(ctx(), "loadBuildManifest: ignoring invalid manifest", "path", path)
+		res.Outputs = map[string]string{}
+		res.Precompressed = nil
+	}
+	return res
+}
+
+func (m *buildManifest) save(path string) {
+	d, err := json.MarshalIndent(m, "", "  ")
+	must(err)
+	must(os.WriteFile(path, d, 0644))
+}
+
+func fileSha1HexMust(path string) string {
+	s, err := u.FileSha1Hex(path)
+	must(err)
+	return s
+}
+
+// buildInputs calculates hashes of inputs of generated files
+type buildInputs struct {
+	// hash of all inputs, for files that depend on all cheatsheets
+	// like index.html and search index
+	all   string
+	byURL map[string]string
+	// hash of markdown and of link targets of a cheatsheet, for
+	// its html page and json api
+	csByURL map[string]string
+}
+
+// csLinksHash returns hash of names of cheatsheets linked from cs and
+// whether they exist. Adding or removing a cheatsheet changes how
+// those links are resolved
+func csLinksHash(cs *cheatSheet, cheatsheets []*cheatSheet) string {
+	var a []string
+	ast.WalkFunc(csParseMarkdown(cs), func(node ast.Node, entering bool) ast.WalkStatus {
+		link, ok := node.(*ast.Link)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		name, _, ok := parseCsLink(string(link.Destination))
+		if ok && name != "" {
+			exists := findCheatsheetByName(cheatsheets, name) != nil
+			a = append(a, fmt.Sprintf("%s:%v", name, exists))
+		}
+		return ast.GoToNext
+	})
+	return u.DataSha1Hex([]byte(strings.Join(a, "\n")))
+}
+
+func newBuildInputs(cheatsheets []*cheatSheet) *buildInputs {
+	res := &buildInputs{
+		byURL:   map[string]string{},
+		csByURL: map[string]string{},
+	}
+	cheatsheetTmpl := fileSha1HexMust(filepath.Join(csTmplDir, "cheatsheet.tmpl.html"))
+	printTmpl := fileSha1HexMust(filepath.Join(csTmplDir, "cheatsheet.print.tmpl.html"))
+	// a change in any partial might change any cheatsheet
+	includes := liquidIncludesHash()
+
+	var all []string
+	for _, cs := range cheatsheets {
+		// path is in "suggest edit" link
+		mdHash := buildGeneratorVersion + cs.mdPath + fileSha1HexMust(cs.mdPath) + includes
+		linksHash := csLinksHash(cs, cheatsheets)
+		// cheatsheet pages link to print pages only if they're generated
+		s := mdHash + cheatsheetTmpl + linksHash + fmt.Sprintf("%v", genPrint)
+		res.csByURL[csURL(cs)] = u.DataSha1Hex([]byte(s))
+		res.csByURL[csPrintURL(cs)] = u.DataSha1Hex([]byte(mdHash + printTmpl + linksHash))
+		res.csByURL[csAPIURL(cs)] = u.DataSha1Hex([]byte(mdHash + linksHash))
+		all = append(all, cs.mdPath+":"+mdHash)
+	}
+
+	files := staticFiles()
+	for i := 0; i < len(files); i += 2 {
+		res.byURL[files[i]] = fileSha1HexMust(files[i+1])
+	}
+	for _, name := range []string{"index.tmpl.html", "list.tmpl.html"} {
+		all = append(all, name+":"+fileSha1HexMust(filepath.Join(csTmplDir, name)))
+	}
+	if path := categoriesConfigPath(); fileExists(path) {
+		all = append(all, categoriesConfigName+":"+fileSha1HexMust(path))
+	}
+	sort.Strings(all)
+	all = append(all, "version:"+buildGeneratorVersion)
+	res.all = u.DataSha1Hex([]byte(strings.Join(all, "\n")))
+	return res
+}
+
+// hash returns hash of all inputs for a generated file with a given url
+func (b *buildInputs) hash(uri string) string {
+	if h, ok := b.byURL[uri]; ok {
+		return h
+	}
+	if h, ok := b.csByURL[uri]; ok {
+		return h
+	}
+	return b.all
+}
+
+// memResponseWriter is http.ResponseWriter that collects the content
+type memResponseWriter struct {
+	header http.Header
+	d      []byte
+}
+
+func (w *memResponseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *memResponseWriter) Write(p []byte) (int, error) {
+	w.d = append(w.d, p...)
+	return len(p), nil
+}
+
+func (w *memResponseWriter) WriteHeader(statusCode int) {
+	// no-op
+}
+
+func urlToGeneratedPath(dir string, uri string) string {
+	name := filepath.FromSlash(strings.TrimPrefix(uri, "/"))
+	return filepath.Join(dir, name)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kjk/common/server"
+	"gopkg.in/yaml.v2"
+)
+
+// cheatsheets/categories.yaml is optional and looks like:
+//
+// categories:
+//   - name: JavaScript
+//     description: JavaScript language and libraries
+//     aliases: [JavaScript libraries]
+//     weight: -10
+//
+// Categories are sorted by weight (lower first, default 0). Categories
+// with the same weight are shown in the order they are listed, followed
+// by categories not listed (sorted by name). Uncategorized is always last
+const categoriesConfigName = "categories.yaml"
+
+// category of cheatsheets without 'category' in front matter
+const csUncategorized = "Uncategorized"
+
+type categoryConfig struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Aliases     []string `yaml:"aliases"`
+	// like weight of cheatsheets, lower is shown first. Default is 0
+	Weight int `yaml:"weight"`
+}
+
+type categoriesConfig struct {
+	Categories []*categoryConfig `yaml:"categories"`
+
+	// maps slug of name or alias to category
+	bySlug map[string]*categoryConfig
+}
+
+type csCategory struct {
+	Name        string
+	URL         string
+	Description string
+	Cheatsheets []*cheatSheet
+	Count       int
+
+	weight int
+	order  int
+}
+
+func categoriesConfigPath() string {
+	return filepath.Join(csDir, categoriesConfigName)
+}
+
+// loadCategoriesConfig loads categories.yaml. It's not an error if
+// it doesn't exist. If it's invalid we log and ignore it
+func loadCategoriesConfig() *categoriesConfig {
+	res := &categoriesConfig{}
+	path := categoriesConfigPath()
+	d, err := os.ReadFile(path)
+	if err == nil {
+		err = yaml.Unmarshal(d, res)
+		if err != nil {
+			logerrf(ctx(), "%s: %s\n", path, err)
+			res = &categoriesConfig{}
+		}
+	}
+	res.bySlug = map[string]*categoryConfig{}
+	for _, c := range res.Categories {
+		res.bySlug[slugify(c.Name)] = c
+		for _, alias := range c.Aliases {
+			res.bySlug[slugify(alias)] = c
+		}
+	}
+	return res
+}
+
+// normalize returns canonical name of category. Category names are
+// matched ignoring case and punctuation, aliases are resolved
+func (c *categoriesConfig) normalize(name string) string {
+	name = strings.TrimSpace(name)
+	if slugify(name) == "" {
+		return csUncategorized
+	}
+	if cat, ok := c.bySlug[slugify(name)]; ok {
+		return cat.Name
+	}
+	return name
+}
+
+func (c *categoriesConfig) csCategory(cs *cheatSheet) string {
+	return c.normalize(cs.meta.Category)
+}
+
+func categoryURL(name string) string {
+	return "/category/" + slugify(name) + ".html"
+}
+
+// buildCategories groups cheatsheets by category
+func buildCategories(cheatsheets []*cheatSheet, cfg *categoriesConfig) []*csCategory {
+	order := map[string]int{}
+	for i, c := range cfg.Categories {
+		order[slugify(c.Name)] = i + 1
+	}
+
+	bySlug := map[string]*csCategory{}
+	for _, cs := range cheatsheets {
+		name := cfg.csCategory(cs)
+		slug := slugify(name)
+		cat := bySlug[slug]
+		if cat == nil {
+			cat = &csCategory{
+				Name:  name,
+				URL:   categoryURL(name),
+				order: order[slug],
+			}
+			if c, ok := cfg.bySlug[slug]; ok {
+				cat.Description = c.Description
+				cat.weight = c.Weight
+			}
+			if name == csUncategorized {
+				cat.weight = math.MaxInt32
+				cat.order = len(cfg.Categories) + 2
+			} else if cat.order == 0 {
+				cat.order = len(cfg.Categories) + 1
+			}
+			bySlug[slug] = cat
+		}
+		cat.Cheatsheets = append(cat.Cheatsheets, cs)
+		cat.Count++
+	}
+
+	var res []*csCategory
+	for _, cat := range bySlug {
+		sortCheatsheets(cat.Cheatsheets)
+		res = append(res, cat)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].weight != res[j].weight {
+			return res[i].weight < res[j].weight
+		}
+		if res[i].order != res[j].order {
+			return res[i].order < res[j].order
+		}
+		return strings.ToLower(res[i].Name) < strings.ToLower(res[j].Name)
+	})
+	return res
+}
+
+// buildContentCategories returns handler for /category/${category}.html pages
+func buildContentCategories(cheatsheets []*cheatSheet, cfg *categoriesConfig) []server.Handler {
+	categories := buildCategories(cheatsheets, cfg)
+	matches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
+		for _, cat := range categories {
+			if uri == cat.URL {
+				return func(w http.ResponseWriter, r *http.Request) {
+					html := genListHTML(cat.Name, cat.Description, cat.Cheatsheets)
+					server.MakeServeContent(uri, html)(w, r)
+				}
+			}
+		}
+		return nil
+	}
+	urls := func() []string {
+		var res []string
+		for _, cat := range categories {
+			res = append(res, cat.URL)
+		}
+		return res
+	}
+	return []server.Handler{server.NewDynamicHandler(matches, urls)}
+}
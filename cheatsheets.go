@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/fs"
+	"os"
 	"path/filepath"
-	"regexp"
 	"runtime"
 	"sort"
 	"strings"
@@ -34,13 +36,25 @@ func newCsMarkdownParser() *parser.Parser {
 	return parser.NewWithExtensions(extensions)
 }
 
-func csBuildToc(doc ast.Node, path string) []*tocNode {
-	//logf("csBuildToc: %s\n", path)
+func csBuildToc(doc ast.Node, cs *cheatSheet) ([]*tocNode, error) {
+	//logf("csBuildToc: %s\n", cs.mdPath)
 	//ast.Print(os.Stdout, doc)
 
+	var err error
+	// we only calculate lines of headings if there's an error
+	headingErr := func(h *ast.Heading, format string, args ...interface{}) {
+		if err == nil {
+			line := csHeadingLines(cs, doc)[h]
+			err = newCsError(cs.mdPath, line, format, args...)
+		}
+	}
+
 	taken := map[string]bool{}
-	ensureUniqueID := func(id string) {
-		panicIf(taken[id], "duplicate heading id '%s' in '%s'", id, path)
+	ensureUniqueID := func(h *ast.Heading) {
+		id := h.HeadingID
+		if taken[id] {
+			headingErr(h, "duplicate heading id '%s'", id)
+		}
 		taken[id] = true
 	}
 
@@ -54,7 +68,7 @@ func csBuildToc(doc ast.Node, path string) []*tocNode {
 			if entering {
 				currHeading = v
 			} else {
-				ensureUniqueID(currHeading.HeadingID)
+				ensureUniqueID(currHeading)
 				tn := &tocNode{
 					heading:      currHeading,
 					Content:      currHeadingContent,
@@ -89,75 +103,17 @@ func csBuildToc(doc ast.Node, path string) []*tocNode {
 			logf(ctx(), "h%d #%s %s %d siblings\n", tn.HeadingLevel, tn.heading.HeadingID, tn.Content, tn.SiblingsCount)
 		}
 	}
-	cloneNode := func(n *tocNode) *tocNode {
-		// clone but without children
-		return &tocNode{
-			heading:       n.heading,
-			Content:       n.Content,
-			HeadingLevel:  n.HeadingLevel,
-			ID:            n.ID,
-			SiblingsCount: n.SiblingsCount,
-			Class:         n.Class,
-		}
+	if err != nil {
+		return nil, err
 	}
-
-	buildToc := func() []*tocNode {
-		first := cloneNode(allHeaders[0])
-		toc := []*tocNode{first}
-		stack := []*tocNode{first}
-		for _, node := range allHeaders[1:] {
-			node = cloneNode(node)
-			stackLastIdx := len(stack) - 1
-			curr := stack[stackLastIdx]
-			currLevel := curr.HeadingLevel
-			nodeLevel := node.HeadingLevel
-			if nodeLevel > currLevel {
-				// this is a child
-				// TODO: should synthesize if we skip more than 1 level?
-				panicIf(nodeLevel-currLevel > 1, "skipping more than 1 level in %s, '%s'", path, node.Content)
-				curr.Children = append(curr.Children, node)
-				stack = append(stack, node)
-				curr = node
-			} else if nodeLevel == currLevel {
-				// this is a sibling, make current and attach to
-				stack[stackLastIdx] = node
-				if stackLastIdx > 0 {
-					parent := stack[stackLastIdx-1]
-					parent.Children = append(parent.Children, node)
-				} else {
-					toc = append(toc, node)
-				}
-			} else {
-				// nodeLevel < currLevel
-				for stackLastIdx > 0 {
-					if stackLastIdx == 1 {
-						toc = append(toc, node)
-						stack = []*tocNode{node}
-						stackLastIdx = 0
-					} else {
-						stack = stack[:stackLastIdx]
-						stackLastIdx--
-						curr = stack[stackLastIdx]
-						if curr.HeadingLevel == nodeLevel {
-							stack[stackLastIdx] = node
-							parent := stack[stackLastIdx-1]
-							parent.Children = append(parent.Children, node)
-							stackLastIdx = 0
-						}
-					}
-				}
-			}
-		}
-		// remove intro if at the top level
-		for i, node := range toc {
-			if node.ID == "intro" && len(node.Children) == 0 {
-				toc = append(toc[:i], toc[i+1:]...)
-				return toc
-			}
+	toc := hoistSynthesizedTocNodes(buildTocTree(allHeaders))
+	// remove intro if at the top level
+	for i, node := range toc {
+		if node.ID == "intro" && len(node.Children) == 0 {
+			toc = append(toc[:i], toc[i+1:]...)
+			break
 		}
-		return toc
 	}
-	toc := buildToc()
 	if false {
 		printToc(toc, 0)
 	}
@@ -173,9 +129,76 @@ func csBuildToc(doc ast.Node, path string) []*tocNode {
 			c.Class = cls
 		}
 	}
+	return toc, nil
+}
+
+// buildTocTree builds a tree out of flat list of headings in document order
+// Top-level nodes have the smallest heading level. If a heading skips levels
+// (e.g. h4 after h2 or a document starting with h3 followed by h2) we
+// synthesize intermediate nodes (with heading == nil) so that a child
+// is always exactly 1 level below its parent
+func buildTocTree(headers []*tocNode) []*tocNode {
+	if len(headers) == 0 {
+		return nil
+	}
+	minLevel := headers[0].HeadingLevel
+	for _, n := range headers {
+		if n.HeadingLevel < minLevel {
+			minLevel = n.HeadingLevel
+		}
+	}
+
+	var toc []*tocNode
+	// stack[i] is the last node at level minLevel + i
+	var stack []*tocNode
+	appendNode := func(node *tocNode) {
+		if len(stack) == 0 {
+			toc = append(toc, node)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+		}
+		stack = append(stack, node)
+	}
+	for _, n := range headers {
+		// clone but without children
+		node := &tocNode{
+			heading:       n.heading,
+			Content:       n.Content,
+			HeadingLevel:  n.HeadingLevel,
+			ID:            n.ID,
+			SiblingsCount: n.SiblingsCount,
+			Class:         n.Class,
+		}
+		depth := node.HeadingLevel - minLevel
+		if len(stack) > depth {
+			stack = stack[:depth]
+		}
+		for len(stack) < depth {
+			appendNode(&tocNode{
+				HeadingLevel: minLevel + len(stack),
+			})
+		}
+		appendNode(node)
+	}
 	return toc
 }
 
+// hoistSynthesizedTocNodes replaces synthesized top-level nodes with
+// their children. They have nothing to show in the toc so this keeps
+// e.g. a cheatsheet with h3 sections followed by h2 looking like before
+func hoistSynthesizedTocNodes(toc []*tocNode) []*tocNode {
+	var res []*tocNode
+	for _, n := range toc {
+		if n.heading == nil {
+			res = append(res, hoistSynthesizedTocNodes(n.Children)...)
+		} else {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
 func printToc(nodes []*tocNode, indent int) {
 	indentStr := func(indent int) string {
 		return "............................"[:indent]
@@ -187,24 +210,19 @@ func printToc(nodes []*tocNode, indent int) {
 	for _, n := range nodes {
 		s := indentStr(indent)
 		hdr := hdrStr(n.HeadingLevel)
-		logf(ctx(), "%s%s %s\n", s, hdr, n.Content)
+		content := n.Content
+		if n.heading == nil {
+			content = "(synthesized)"
+		}
+		logf(ctx(), "%s%s %s\n", s, hdr, content)
 		printToc(n.Children, indent+1)
 	}
 }
 
-var reg *regexp.Regexp
-
-func init() {
-	reg = regexp.MustCompile(`{:.*}`)
-}
-
 func cleanupMarkdown(md []byte) []byte {
 	s := string(md)
-	// TODO: implement support of this in markdown parser
-	// remove lines like: {: data-line="1"}
-	s = reg.ReplaceAllString(s, "")
-	s = strings.Replace(s, "{% raw %}", "", -1)
-	s = strings.Replace(s, "{% endraw %}", "", -1)
+	// lines like: {: data-line="1"} are handled by applyKramdownAttrs()
+	// Liquid tags are handled by liquidPreprocess()
 	prev := s
 	for prev != s {
 		prev = s
@@ -221,62 +239,66 @@ type cheatSheet struct {
 	// TODO: rename htmlFileName
 	PathHTML   string // path relative to . directory
 	mdWithMeta []byte
-	md         []byte
-	meta       map[string]string
+	md         []byte // without front matter and with Liquid tags evaluated
+	meta       *csMeta
 	Title      string
-	inMain     bool // if true shown in /index.html, if false only in /all.html
+	err        error // set if processCheatSheet() failed
+	// csStatusPublished etc.
+	status string
+	// mdLines[i] is line number in .md file of i-th line of md
+	mdLines []int
+	// unsupported Liquid tags
+	liquidWarnings []*csError
 }
 
-func processCheatSheet(cs *cheatSheet) {
+func processCheatSheet(cs *cheatSheet) error {
 	//logf("processCheatSheet: '%s'\n", cs.mdPath)
-	cs.mdWithMeta = readFileMust(cs.mdPath)
-	md := normalizeNewlinesInPlace(cs.mdWithMeta)
-	lines := strings.Split(string(md), "\n")
-	// skip empty lines at the beginning
-	for len(lines[0]) == 0 {
-		lines = lines[1:]
-	}
-	if lines[0] != "---" {
-		// no metadata
-		cs.md = []byte(strings.Join(lines, "\n"))
-		return
-	}
-	metaLines := []string{}
-	lines = lines[1:]
-	for lines[0] != "---" {
-		metaLines = append(metaLines, lines[0])
-		lines = lines[1:]
-	}
-	lines = lines[1:]
-	cs.md = []byte(strings.Join(lines, "\n"))
-	//logf("meta for '%s':\n%s\n", cs.mdPath, strings.Join(metaLines, "\n"))
-	lastName := ""
-	for _, line := range metaLines {
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) == 1 {
-			s := strings.TrimSpace(parts[0])
-			s = strings.Trim(s, `"`)
-			v := cs.meta[lastName]
-			if len(v) > 0 {
-				v = v + "\n"
-			}
-			v += s
-			cs.meta[lastName] = v
-		} else {
-			name := parts[0]
-			name = strings.TrimSpace(name)
-			name = strings.ToLower(name)
-			s := strings.TrimSpace(parts[1])
-			s = strings.Trim(s, `"`)
-			s = strings.TrimLeft(s, "|")
-			cs.meta[name] = s
-			lastName = name
-		}
-	}
-	cs.Title = cs.meta["title"]
-	if cs.Title == "" {
-		cs.Title = cs.fileNameBase
+	cs.err = nil
+	cs.meta = &csMeta{}
+	cs.md = nil
+	cs.mdLines = nil
+	cs.liquidWarnings = nil
+	cs.Title = cs.fileNameBase
+	cs.status = csStatusFromPath(cs.mdPath)
+	d, err := os.ReadFile(cs.mdPath)
+	if err != nil {
+		cs.err = newCsError(cs.mdPath, 0, "%s", err)
+		return cs.err
+	}
+	// normalizing shrinks the data in place so we must use the returned slice
+	cs.mdWithMeta = normalizeNewlinesInPlace(d)
+	md := cs.mdWithMeta
+	meta, md, err := parseFrontMatter(md, cs.mdPath)
+	if err != nil {
+		cs.err = err
+		return err
+	}
+	cs.meta = meta
+	cs.meta.Tags = normalizeTags(meta.Tags)
+	if meta.Status != "" {
+		cs.status = meta.Status
+	}
+	nAllLines := bytes.Count(cs.mdWithMeta, []byte("\n"))
+	firstLine := nAllLines - bytes.Count(md, []byte("\n")) + 1
+	md, cs.mdLines, cs.liquidWarnings, err = liquidPreprocess(md, cs.mdPath, firstLine, meta)
+	if err != nil {
+		cs.err = err
+		return err
+	}
+	cs.md = md
+	if cs.meta.Title != "" {
+		cs.Title = cs.meta.Title
 	}
+	return nil
+}
+
+// reprocessCheatSheet re-reads cs from disk so that the dev server shows
+// the latest version. cs is shared by concurrent requests so we return
+// a processed copy instead of modifying it. Error is in cs.err
+func reprocessCheatSheet(cs *cheatSheet) *cheatSheet {
+	c := *cs
+	processCheatSheet(&c)
+	return &c
 }
 
 type tocNode struct {
@@ -298,7 +320,14 @@ type tocNode struct {
 }
 
 func genHeadingTocHTML(node *tocNode, level int) {
-	nChildren := len(node.Children)
+	// synthesized children have no heading to link to
+	var children []*tocNode
+	for _, c := range node.Children {
+		if c.heading != nil {
+			children = append(children, c)
+		}
+	}
+	nChildren := len(children)
 	buildToc := func() {
 		shouldBuild := ((level >= 2) || (node.SiblingsCount == 0))
 		if nChildren == 0 || !shouldBuild {
@@ -307,7 +336,7 @@ func genHeadingTocHTML(node *tocNode, level int) {
 
 		gen := func(active *tocNode) []byte {
 			s := `<div class="toc-mini">`
-			for i, c := range node.Children {
+			for i, c := range children {
 				if c == active {
 					s += fmt.Sprintf(`<b>%s</b>`, c.Content)
 				} else {
@@ -411,19 +440,35 @@ func buildFlatToc(toc []*tocNode, tocLevel int) []*tocNode {
 	return res
 }
 
-func genCheatsheetHTML(cs *cheatSheet) []byte {
-	logf(ctx(), "csGenHTML: for '%s'\n", cs.mdPath)
+// csParseMarkdown parses markdown of a cheatsheet into ast
+func csParseMarkdown(cs *cheatSheet) ast.Node {
 	md := cleanupMarkdown(cs.md)
-
 	parser := newCsMarkdownParser()
-
 	doc := markdown.Parse(md, parser)
-	toc := csBuildToc(doc, cs.mdPath)
+	applyKramdownAttrs(doc)
+	return doc
+}
+
+// cheatsheets is used to resolve links to other cheatsheets
+func genCheatsheetHTML(ctx context.Context, cs *cheatSheet, cheatsheets []*cheatSheet) ([]byte, error) {
+	logInfo(ctx, "genCheatsheetHTML", "path", cs.mdPath)
+	if cs.err != nil {
+		return nil, cs.err
+	}
+	doc := csParseMarkdown(cs)
+	resolveCsLinks(doc, cheatsheets)
+	toc, err := csBuildToc(doc, cs)
+	if err != nil {
+		return nil, err
+	}
 	tocFlat := buildFlatToc(toc, 0)
 
 	// [[text, text.toLowerCase(), id, tocLevel], ...]
 	searchIndex := [][]interface{}{}
 	for _, toc := range tocFlat {
+		if toc.heading == nil {
+			continue
+		}
 		s := toc.Content
 		v := []interface{}{s, strings.ToLower(s), toc.ID, toc.TocLevel}
 		searchIndex = append(searchIndex, v)
@@ -434,15 +479,26 @@ func genCheatsheetHTML(cs *cheatSheet) []byte {
 	renderer := newMarkdownHTMLRenderer("")
 	mdHTML := string(markdown.Render(doc, renderer))
 
-	tpl := string(readFileMust(filepath.Join(csTmplDir, "cheatsheet.tmpl.html")))
+	tplPath := filepath.Join(csTmplDir, "cheatsheet.tmpl.html")
+	tpl, err := os.ReadFile(tplPath)
+	if err != nil {
+		return nil, err
+	}
 
 	// on windows mdFileName is a windows-style path so change to unix/url style
 	mdFileName := strings.Replace(cs.mdFileName, `\`, "/", -1)
 
 	searchIndexJSON, err := json.Marshal(searchIndex)
-	must(err)
+	if err != nil {
+		return nil, err
+	}
 
-	ctx := map[string]interface{}{
+	printURL := ""
+	if genPrint {
+		printURL = csPrintURL(cs)
+	}
+
+	tplCtx := map[string]interface{}{
 		"toc": toc,
 		//"tocflat":    tocFlat,
 		"title":             cs.Title,
@@ -450,49 +506,44 @@ func genCheatsheetHTML(cs *cheatSheet) []byte {
 		"content":           mdHTML,
 		"searchIndexStatic": string(searchIndexJSON),
 		"alpineURL":         alpineURL,
+		"wip":               csHasTag(cs, csTagWIP),
+		"printURL":          printURL,
+		"liveReload":        liveReload,
+		"liveReloadURL":     liveReloadURL,
 	}
 
-	return []byte(raymond.MustRender(tpl, ctx))
+	s, err := raymond.Render(string(tpl), tplCtx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", tplPath, err)
+	}
+	return []byte(s), nil
 }
 
-func genIndexHTML(cheatsheets []*cheatSheet) string {
-	// sort by title
-	sort.Slice(cheatsheets, func(i, j int) bool {
-		t1 := strings.ToLower(cheatsheets[i].Title)
-		t2 := strings.ToLower(cheatsheets[j].Title)
-		return t1 < t2
-	})
-
-	byCat := map[string][]*cheatSheet{}
-	for _, cs := range cheatsheets {
-		cat := cs.meta["category"]
-		if cat == "" {
-			continue
+// sortCheatsheets sorts by weight from front matter (lower first,
+// default is 0) and by title if weights are the same
+func sortCheatsheets(a []*cheatSheet) {
+	sort.SliceStable(a, func(i, j int) bool {
+		w1, w2 := a[i].meta.Weight, a[j].meta.Weight
+		if w1 != w2 {
+			return w1 < w2
 		}
-		byCat[cat] = append(byCat[cat], cs)
-	}
+		return strings.ToLower(a[i].Title) < strings.ToLower(a[j].Title)
+	})
+}
 
-	// build toc for categories
-	categories := []string{}
-	for cat := range byCat {
-		categories = append(categories, cat)
-	}
-	sort.Strings(categories)
-	cats := []map[string]interface{}{}
-	for _, category := range categories {
-		v := map[string]interface{}{}
-		v["category"] = category
-		catMetas := byCat[category]
-		v["cheatsheets"] = catMetas
-		cats = append(cats, v)
-	}
+// tags are tags of all cheatsheets, not only those shown in the index
+func genIndexHTML(cheatsheets []*cheatSheet, categories []*csCategory, tags []*csTag) string {
+	sortCheatsheets(cheatsheets)
 
 	tpl := string(readFileMust(filepath.Join(csTmplDir, "index.tmpl.html")))
 	ctx := map[string]interface{}{
 		"cheatsheets":      cheatsheets,
 		"CheatsheetsCount": len(cheatsheets),
-		"categories":       cats,
+		"categories":       categories,
+		"tags":             tags,
 		"alpineURL":        alpineURL,
+		"liveReload":       liveReload,
+		"liveReloadURL":    liveReloadURL,
 	}
 	s := raymond.MustRender(tpl, ctx)
 	return s
@@ -507,10 +558,14 @@ func readCheatSheets() []*cheatSheet {
 			if err != nil {
 				return nil
 			}
+			name := f.Name()
 			if f.IsDir() {
+				if name == csIncludesDir {
+					// partials for {% include %}, not cheatsheets
+					return filepath.SkipDir
+				}
 				return nil
 			}
-			name := f.Name()
 			if filepath.Ext(name) != ".md" {
 				return nil
 			}
@@ -523,8 +578,6 @@ func readCheatSheets() []*cheatSheet {
 				fileNameBase: baseName,
 				mdPath:       path,
 				mdFileName:   path, // TODO: something else?
-				meta:         map[string]string{},
-				inMain:       strings.Contains(path, "good"),
 			}
 
 			//logf("%s\n", cs.mdPath)
@@ -563,7 +616,13 @@ func readCheatSheets() []*cheatSheet {
 		wg.Add(1)
 		sem <- true
 		go func(cs *cheatSheet) {
-			processCheatSheet(cs)
+			err := processCheatSheet(cs)
+			if err != nil {
+				logerrf(ctx(), "%s\n", err)
+			}
+			for _, w := range cs.liquidWarnings {
+				logvf(ctx(), "%s\n", w)
+			}
 			//logf("Processed %s, html size: %d\n", cs.mdPath, len(cs.html))
 			wg.Done()
 			<-sem
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// mkTocHeaders creates a flat list of headings from "h2:a h4:b"
+func mkTocHeaders(s string) []*tocNode {
+	var res []*tocNode
+	for _, part := range strings.Fields(s) {
+		parts := strings.SplitN(part, ":", 2)
+		level, err := strconv.Atoi(strings.TrimPrefix(parts[0], "h"))
+		must(err)
+		n := &tocNode{
+			heading:      &ast.Heading{Level: level},
+			Content:      parts[1],
+			HeadingLevel: level,
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+// tocTreeString returns toc as "a[_[b]] c" where _ is a synthesized node
+func tocTreeString(toc []*tocNode) string {
+	var a []string
+	for _, n := range toc {
+		s := n.Content
+		if n.heading == nil {
+			s = "_"
+		}
+		if len(n.Children) > 0 {
+			s += "[" + tocTreeString(n.Children) + "]"
+		}
+		a = append(a, s)
+	}
+	return strings.Join(a, " ")
+}
+
+func tocTreeDepth(toc []*tocNode) int {
+	res := 0
+	for _, n := range toc {
+		if d := 1 + tocTreeDepth(n.Children); d > res {
+			res = d
+		}
+	}
+	return res
+}
+
+// checkTocLevels checks that a child is always exactly 1 level below its parent
+func checkTocLevels(t *testing.T, toc []*tocNode) {
+	t.Helper()
+	for _, n := range toc {
+		for _, c := range n.Children {
+			if c.HeadingLevel != n.HeadingLevel+1 {
+				t.Errorf("child '%s' (h%d) of '%s' (h%d) is not 1 level below its parent", c.Content, c.HeadingLevel, n.Content, n.HeadingLevel)
+			}
+		}
+		checkTocLevels(t, n.Children)
+	}
+}
+
+func TestBuildTocTree(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		// expected tree, depth and tree after hoistSynthesizedTocNodes()
+		tree    string
+		depth   int
+		hoisted string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:    "regular",
+			headers: "h2:a h3:b h3:c h2:d",
+			tree:    "a[b c] d",
+			depth:   2,
+			hoisted: "a[b c] d",
+		},
+		{
+			name:    "h3 first with no h2",
+			headers: "h3:a h3:b h2:c h3:d",
+			tree:    "_[a b] c[d]",
+			depth:   2,
+			hoisted: "a b c[d]",
+		},
+		{
+			name:    "h2 h4 h2",
+			headers: "h2:a h4:b h2:c",
+			tree:    "a[_[b]] c",
+			depth:   3,
+			hoisted: "a[_[b]] c",
+		},
+		{
+			name:    "h4 h3 h2",
+			headers: "h4:a h3:b h2:c",
+			tree:    "_[_[a] b] c",
+			depth:   3,
+			hoisted: "a b c",
+		},
+		{
+			name:    "repeated skipped levels",
+			headers: "h1:a h3:b h3:c h5:d h2:e h4:f",
+			tree:    "a[_[b c[_[d]]] e[_[f]]]",
+			depth:   5,
+			hoisted: "a[_[b c[_[d]]] e[_[f]]]",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			toc := buildTocTree(mkTocHeaders(tc.headers))
+			if got := tocTreeString(toc); got != tc.tree {
+				t.Errorf("tree: got '%s', want '%s'", got, tc.tree)
+			}
+			if got := tocTreeDepth(toc); got != tc.depth {
+				t.Errorf("depth: got %d, want %d", got, tc.depth)
+			}
+			checkTocLevels(t, toc)
+			if got := tocTreeString(hoistSynthesizedTocNodes(toc)); got != tc.hoisted {
+				t.Errorf("hoisted: got '%s', want '%s'", got, tc.hoisted)
+			}
+		})
+	}
+}
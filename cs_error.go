@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aymerick/raymond"
+)
+
+// csError is an error in a cheatsheet, with a location if known
+type csError struct {
+	path string
+	line int // 1-based, 0 if not known
+	msg  string
+}
+
+func (e *csError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.path, e.line, e.msg)
+	}
+	return fmt.Sprintf("%s: %s", e.path, e.msg)
+}
+
+func newCsError(path string, line int, format string, args ...interface{}) *csError {
+	return &csError{
+		path: path,
+		line: line,
+		msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+// asCsError returns location of the error in a cheatsheet, if it has it
+func asCsError(err error) *csError {
+	var e *csError
+	if errors.As(err, &e) {
+		return e
+	}
+	return nil
+}
+
+// number of lines of source shown before and after the line with error
+const csErrorContextLines = 5
+
+// genCsErrorHTML generates a page showing err and, if we know the line,
+// the part of cheatsheet source around it
+func genCsErrorHTML(cs *cheatSheet, err error) ([]byte, error) {
+	ctx := map[string]interface{}{
+		"path":          cs.mdPath,
+		"msg":           err.Error(),
+		"liveReload":    liveReload,
+		"liveReloadURL": liveReloadURL,
+	}
+	if e := asCsError(err); e != nil {
+		ctx["path"] = e.path
+		ctx["msg"] = e.msg
+		ctx["line"] = e.line
+		d, _ := os.ReadFile(e.path)
+		if e.line > 0 && len(d) > 0 {
+			srcLines := strings.Split(string(normalizeNewlinesInPlace(d)), "\n")
+			start := e.line - csErrorContextLines
+			if start < 1 {
+				start = 1
+			}
+			end := e.line + csErrorContextLines
+			if end > len(srcLines) {
+				end = len(srcLines)
+			}
+			var lines []map[string]interface{}
+			for no := start; no <= end; no++ {
+				lines = append(lines, map[string]interface{}{
+					"no":    no,
+					"text":  srcLines[no-1],
+					"isErr": no == e.line,
+				})
+			}
+			ctx["lines"] = lines
+		}
+	}
+	tplPath := filepath.Join(csTmplDir, "error.tmpl.html")
+	tpl, err := os.ReadFile(tplPath)
+	if err != nil {
+		return nil, err
+	}
+	s, err := raymond.Render(string(tpl), ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", tplPath, err)
+	}
+	return []byte(s), nil
+}
+
+// serveCsError sends a page describing error in a cheatsheet
+func serveCsError(ctx context.Context, w http.ResponseWriter, cs *cheatSheet, err error) {
+	logError(ctx, "serveCsError", "err", err)
+	html, tplErr := genCsErrorHTML(cs, err)
+	if tplErr != nil {
+		logError(ctx, "serveCsError: genCsErrorHTML() failed", "err", tplErr)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(html)
+}
+
+// serveCsRenderError sends an error page (or a plain error for .json
+// urls) when rendering cs failed
+func serveCsRenderError(w http.ResponseWriter, r *http.Request, cs *cheatSheet, err error) {
+	// generateStatic() only renders cheatsheets without errors
+	panicIf(r == nil, "%s", err)
+	ctx := reqCtx(r)
+	if strings.HasSuffix(r.URL.Path, ".json") {
+		logError(ctx, "serveCsRenderError", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveCsError(ctx, w, cs, err)
+}
+
+// validateCheatsheets returns cheatsheets that can be rendered
+// and errors for those that can't
+func validateCheatsheets(cheatsheets []*cheatSheet) ([]*cheatSheet, []error) {
+	var good []*cheatSheet
+	var errs []error
+	for _, cs := range cheatsheets {
+		err := cs.err
+		if err == nil {
+			doc := csParseMarkdown(cs)
+			_, err = csBuildToc(doc, cs)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		good = append(good, cs)
+	}
+	return good, errs
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kjk/common/server"
+)
+
+// sitemap.xml, robots.txt and Atom feed of recently updated cheatsheets
+
+const (
+	siteURL = "https://www.referenceguide.dev"
+
+	feedMaxEntries = 25
+)
+
+// gitLastModified returns time of last commit for every file under dir
+// returns empty map if git is not available
+func gitLastModified(dir string) map[string]time.Time {
+	res := map[string]time.Time{}
+	cmd := exec.Command("git", "log", "--format=@%ct", "--name-only", "--", dir)
+	out, err := cmd.Output()
+	if err != nil {
+		logvf(ctx(), "gitLastModified: '%s' failed with '%s'\n", cmd, err)
+		return res
+	}
+	// output is newest first:
+	// @1634567890
+	//
+	// cheatsheets/good/go.md
+	var t time.Time
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "@") {
+			secs, err := strconv.ParseInt(line[1:], 10, 64)
+			if err == nil {
+				t = time.Unix(secs, 0).UTC()
+			}
+			continue
+		}
+		path := filepath.FromSlash(line)
+		if _, ok := res[path]; !ok {
+			res[path] = t
+		}
+	}
+	return res
+}
+
+// csLastModified returns when cheatsheet was last updated: from
+// 'updated' in front matter, time of last git commit or file time
+func csLastModified(cheatsheets []*cheatSheet) map[*cheatSheet]time.Time {
+	res := map[*cheatSheet]time.Time{}
+	var gitTimes map[string]time.Time
+	for _, cs := range cheatsheets {
+		if !cs.meta.Updated.IsZero() {
+			res[cs] = cs.meta.Updated
+			continue
+		}
+		if gitTimes == nil {
+			gitTimes = gitLastModified(csDir)
+		}
+		if t, ok := gitTimes[filepath.Clean(cs.mdPath)]; ok {
+			res[cs] = t
+			continue
+		}
+		if st, err := os.Stat(cs.mdPath); err == nil {
+			res[cs] = st.ModTime().UTC()
+		}
+	}
+	return res
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name      `xml:"urlset"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	URLs    []*sitemapURL `xml:"url"`
+}
+
+func marshalXML(v interface{}) []byte {
+	d, err := xml.MarshalIndent(v, "", "  ")
+	must(err)
+	return append([]byte(xml.Header), append(d, '\n')...)
+}
+
+// csInFeeds returns true if cs should be in sitemap.xml and atom.xml
+// Drafts, obsolete and archived cheatsheets are not
+func csInFeeds(cs *cheatSheet) bool {
+	return csListedIn(cs, "/all.html") && !csHasTag(cs, csTagArchived)
+}
+
+func filterCsInFeeds(cheatsheets []*cheatSheet) []*cheatSheet {
+	var res []*cheatSheet
+	for _, cs := range cheatsheets {
+		if csInFeeds(cs) {
+			res = append(res, cs)
+		}
+	}
+	return res
+}
+
+func genSitemapXML(cheatsheets []*cheatSheet, lastMod map[*cheatSheet]time.Time) []byte {
+	cheatsheets = filterCsInFeeds(cheatsheets)
+	v := &sitemapURLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+	}
+	for _, uri := range []string{"/", "/all.html"} {
+		v.URLs = append(v.URLs, &sitemapURL{Loc: siteURL + uri})
+	}
+	for _, cat := range buildCategories(cheatsheets, loadCategoriesConfig()) {
+		v.URLs = append(v.URLs, &sitemapURL{Loc: siteURL + cat.URL})
+	}
+	for _, tag := range buildTags(cheatsheets) {
+		v.URLs = append(v.URLs, &sitemapURL{Loc: siteURL + tag.URL})
+	}
+	for _, cs := range cheatsheets {
+		u := &sitemapURL{Loc: siteURL + csURL(cs)}
+		if t, ok := lastMod[cs]; ok {
+			u.LastMod = t.Format("2006-01-02")
+		}
+		v.URLs = append(v.URLs, u)
+	}
+	return marshalXML(v)
+}
+
+func genRobotsTxt() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("User-agent: *\n")
+	buf.WriteString("Disallow: /api/\n")
+	fmt.Fprintf(&buf, "\nSitemap: %s/sitemap.xml\n", siteURL)
+	return buf.Bytes()
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string    `xml:"title"`
+	Link    *atomLink `xml:"link"`
+	ID      string    `xml:"id"`
+	Updated string    `xml:"updated"`
+	Summary string    `xml:"summary,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"feed"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Links   []*atomLink  `xml:"link"`
+	Updated string       `xml:"updated"`
+	Author  string       `xml:"author>name"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+// genAtomFeed returns Atom feed of most recently updated cheatsheets
+// Drafts are only shown in /drafts.html so they're not in the feed
+func genAtomFeed(cheatsheets []*cheatSheet, lastMod map[*cheatSheet]time.Time) []byte {
+	var a []*cheatSheet
+	for _, cs := range filterCsInFeeds(cheatsheets) {
+		if _, ok := lastMod[cs]; ok {
+			a = append(a, cs)
+		}
+	}
+	sort.SliceStable(a, func(i, j int) bool {
+		ti, tj := lastMod[a[i]], lastMod[a[j]]
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return a[i].fileNameBase < a[j].fileNameBase
+	})
+	if len(a) > feedMaxEntries {
+		a = a[:feedMaxEntries]
+	}
+
+	feed := &atomFeed{
+		Xmlns: "http://www.w3.org/2005/Atom",
+		Title: "Recently updated cheatsheets",
+		ID:    siteURL + "/",
+		Links: []*atomLink{
+			{Href: siteURL + "/"},
+			{Href: siteURL + "/atom.xml", Rel: "self"},
+		},
+		Author: "Krzysztof Kowalczyk",
+	}
+	for i, cs := range a {
+		updated := lastMod[cs].UTC().Format(time.RFC3339)
+		if i == 0 {
+			feed.Updated = updated
+		}
+		summary := cs.meta.Description
+		if summary == "" {
+			summary = strings.TrimSpace(cs.meta.Intro)
+		}
+		uri := siteURL + csURL(cs)
+		feed.Entries = append(feed.Entries, &atomEntry{
+			Title:   cs.Title,
+			Link:    &atomLink{Href: uri},
+			ID:      uri,
+			Updated: updated,
+			Summary: summary,
+		})
+	}
+	if feed.Updated == "" {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	return marshalXML(feed)
+}
+
+// buildContentFeeds returns handler for /sitemap.xml, /robots.txt and /atom.xml
+func buildContentFeeds(cheatsheets []*cheatSheet) []server.Handler {
+	var (
+		once    sync.Once
+		lastMod map[*cheatSheet]time.Time
+	)
+	getLastMod := func() map[*cheatSheet]time.Time {
+		once.Do(func() {
+			lastMod = csLastModified(cheatsheets)
+		})
+		return lastMod
+	}
+	matches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
+		var d []byte
+		switch uri {
+		case "/sitemap.xml":
+			d = genSitemapXML(cheatsheets, getLastMod())
+		case "/robots.txt":
+			d = genRobotsTxt()
+		case "/atom.xml":
+			d = genAtomFeed(cheatsheets, getLastMod())
+		default:
+			return nil
+		}
+		return server.MakeServeContent(uri, d)
+	}
+	urls := func() []string {
+		return []string{"/sitemap.xml", "/robots.txt", "/atom.xml"}
+	}
+	return []server.Handler{server.NewDynamicHandler(matches, urls)}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// csMeta is YAML front matter of a cheatsheet i.e. the part between
+// "---" lines at the beginning of .md file
+type csMeta struct {
+	Title          string    `yaml:"title"`
+	Category       string    `yaml:"category"`
+	Tags           []string  `yaml:"tags"`
+	Updated        time.Time `yaml:"updated"`
+	Weight         int       `yaml:"weight"`
+	Intro          string    `yaml:"intro"`
+	Keywords       []string  `yaml:"keywords"`
+	Description    string    `yaml:"description"`
+	PrismLanguages []string  `yaml:"prism_languages"`
+	// draft, review, published or obsolete, see csStatusDraft etc.
+	Status string `yaml:"status"`
+
+	// all other keys, like layout or authors
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+var (
+	frontMatterSep = []byte("---")
+	// yaml errors look like: "yaml: line 3: mapping values are not allowed in this context"
+	rxYamlErrLine = regexp.MustCompile(`line (\d+)`)
+	// top-level key at the beginning of the line, like "Title:"
+	rxTopLevelKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*[ \t]*:`)
+)
+
+// lowerCaseTopLevelKeys lowercases top-level keys in front matter
+// so that "Title:" works like "title:". Doesn't change line numbers
+// so that yaml errors still point to the right line
+// returns 1-based line of a key that is a duplicate after lowercasing
+func lowerCaseTopLevelKeys(metaYAML []byte) ([]byte, string, int) {
+	lines := bytes.Split(metaYAML, []byte("\n"))
+	seen := map[string]bool{}
+	for i, line := range lines {
+		loc := rxTopLevelKey.FindIndex(line)
+		if loc == nil {
+			continue
+		}
+		key := bytes.ToLower(line[:loc[1]])
+		copy(line, key)
+		name := strings.TrimSpace(strings.TrimSuffix(string(key), ":"))
+		if seen[name] {
+			return nil, name, i + 1
+		}
+		seen[name] = true
+	}
+	return bytes.Join(lines, []byte("\n")), "", 0
+}
+
+// fixYamlErrorLines changes line numbers in yaml error message from
+// relative to front matter to relative to the whole file
+// returns the message and the first line number in it (0 if none)
+func fixYamlErrorLines(err error, lineOffset int) (string, int) {
+	firstLine := 0
+	s := rxYamlErrLine.ReplaceAllStringFunc(err.Error(), func(s string) string {
+		n, _ := strconv.Atoi(s[len("line "):])
+		n += lineOffset
+		if firstLine == 0 {
+			firstLine = n
+		}
+		return fmt.Sprintf("line %d", n)
+	})
+	return s, firstLine
+}
+
+// splitFrontMatter splits md into front matter and the rest
+// of markdown. meta is nil if there is no front matter.
+// metaLine is 1-based line number in md where front matter starts
+func splitFrontMatter(md []byte) (meta []byte, rest []byte, metaLine int, err error) {
+	lines := bytes.Split(md, []byte("\n"))
+	// skip empty lines at the beginning
+	i := 0
+	for i < len(lines) && len(lines[i]) == 0 {
+		i++
+	}
+	if i == len(lines) || !bytes.Equal(lines[i], frontMatterSep) {
+		// no metadata
+		return nil, bytes.Join(lines[i:], []byte("\n")), 0, nil
+	}
+	start := i + 1
+	for end := start; end < len(lines); end++ {
+		if bytes.Equal(lines[end], frontMatterSep) {
+			meta = bytes.Join(lines[start:end], []byte("\n"))
+			rest = bytes.Join(lines[end+1:], []byte("\n"))
+			return meta, rest, start + 1, nil
+		}
+	}
+	return nil, nil, i + 1, fmt.Errorf("front matter starting with '---' is not terminated with '---'")
+}
+
+// parseFrontMatter extracts and decodes YAML front matter from md
+// returns markdown without front matter
+func parseFrontMatter(md []byte, path string) (*csMeta, []byte, error) {
+	metaYAML, rest, metaLine, err := splitFrontMatter(md)
+	if err != nil {
+		return nil, nil, newCsError(path, metaLine, "%s", err)
+	}
+	meta := &csMeta{}
+	if metaYAML == nil {
+		return meta, rest, nil
+	}
+	metaYAML, dupKey, dupLine := lowerCaseTopLevelKeys(metaYAML)
+	if dupKey != "" {
+		return nil, nil, newCsError(path, metaLine+dupLine-1, "duplicate front matter key '%s' (keys are case-insensitive)", dupKey)
+	}
+	err = yaml.Unmarshal(metaYAML, meta)
+	if err != nil {
+		msg, line := fixYamlErrorLines(err, metaLine-1)
+		if line == 0 {
+			line = metaLine
+		}
+		return nil, nil, newCsError(path, line, "invalid front matter: %s", msg)
+	}
+	if meta.Status != "" && !isValidCsStatus(meta.Status) {
+		line := metaLine
+		for i, l := range bytes.Split(metaYAML, []byte("\n")) {
+			if bytes.HasPrefix(l, []byte("status:")) {
+				line += i
+				break
+			}
+		}
+		return nil, nil, newCsError(path, line, "invalid status '%s', must be one of: %s", meta.Status, strings.Join(csStatuses, ", "))
+	}
+	return meta, rest, nil
+}
@@ -1,14 +1,17 @@
 module github.com/kjk/cheatsheets
 
-go 1.17
+// 1.20 for http.NewResponseController() in live_reload.go
+go 1.20
 
 require (
 	github.com/alecthomas/chroma v0.9.2
 	github.com/andybalholm/brotli v1.0.3
 	github.com/aymerick/raymond v2.0.2+incompatible
+	github.com/fsnotify/fsnotify v1.5.1
 	github.com/gomarkdown/markdown v0.0.0-20210918233619-6c1113f12c4a
 	github.com/kjk/common v0.0.0-20211010082736-d33cbaeed6af
 	github.com/kjk/minio v0.0.0-20211009054212-7bcee50d3b76
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -32,5 +35,4 @@ require (
 	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac // indirect
 	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/ini.v1 v1.63.2 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Kramdown block attributes (inline attribute lists) are lines like:
+// {: .-three-column}
+// {: data-line="2,3"}
+// {:.light}
+// They follow the block they apply to. gomarkdown doesn't understand them
+// so they end up as a separate paragraph or as the last line of a paragraph
+
+var (
+	rxKramdownAttrs     = regexp.MustCompile(`^\{:\s*(.*?)\s*\}$`)
+	rxKramdownAttrToken = regexp.MustCompile(`([#.][^\s#.]+)|([\w-]+)=(?:"([^"]*)"|'([^']*)')`)
+)
+
+// parseKramdownAttrs parses "{: .foo #bar data-line="2"}"
+// returns nil if s is not a kramdown attribute list
+func parseKramdownAttrs(s string) *ast.Attribute {
+	m := rxKramdownAttrs.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil
+	}
+	attr := &ast.Attribute{
+		Attrs: map[string][]byte{},
+	}
+	for _, tok := range rxKramdownAttrToken.FindAllStringSubmatch(m[1], -1) {
+		switch {
+		case strings.HasPrefix(tok[1], "#"):
+			attr.ID = []byte(tok[1][1:])
+		case strings.HasPrefix(tok[1], "."):
+			attr.Classes = append(attr.Classes, []byte(tok[1][1:]))
+		case tok[2] != "":
+			v := tok[3]
+			if v == "" {
+				v = tok[4]
+			}
+			attr.Attrs[tok[2]] = []byte(v)
+		}
+	}
+	return attr
+}
+
+func getBlockAttribute(node ast.Node) *ast.Attribute {
+	if c := node.AsContainer(); c != nil {
+		return c.Attribute
+	}
+	if l := node.AsLeaf(); l != nil {
+		return l.Attribute
+	}
+	return nil
+}
+
+func setBlockAttribute(node ast.Node, attr *ast.Attribute) {
+	if c := node.AsContainer(); c != nil {
+		c.Attribute = attr
+		return
+	}
+	if l := node.AsLeaf(); l != nil {
+		l.Attribute = attr
+	}
+}
+
+// mergeBlockAttribute adds attr to attributes already set on node
+func mergeBlockAttribute(node ast.Node, attr *ast.Attribute) {
+	curr := getBlockAttribute(node)
+	if curr == nil {
+		setBlockAttribute(node, attr)
+		return
+	}
+	if attr.ID != nil {
+		curr.ID = attr.ID
+	}
+	curr.Classes = append(curr.Classes, attr.Classes...)
+	if curr.Attrs == nil {
+		curr.Attrs = map[string][]byte{}
+	}
+	for k, v := range attr.Attrs {
+		curr.Attrs[k] = v
+	}
+}
+
+// removeAstNode removes node from children of its parent
+// unlike ast.RemoveFromTree it works for leaf nodes
+func removeAstNode(node ast.Node) {
+	parent := node.GetParent()
+	if parent == nil {
+		return
+	}
+	var a []ast.Node
+	for _, c := range parent.GetChildren() {
+		if c != node {
+			a = append(a, c)
+		}
+	}
+	parent.SetChildren(a)
+	node.SetParent(nil)
+}
+
+// kramdownAttrsFromLastLine checks if the last line of a paragraph
+// is a kramdown attribute list. If yes, removes it from the paragraph
+func kramdownAttrsFromLastLine(para *ast.Paragraph) *ast.Attribute {
+	children := para.Children
+	if len(children) == 0 {
+		return nil
+	}
+	txt, ok := children[len(children)-1].(*ast.Text)
+	if !ok {
+		return nil
+	}
+	lit := txt.Literal
+	idx := bytes.LastIndexByte(lit, '\n')
+	attr := parseKramdownAttrs(string(lit[idx+1:]))
+	if attr == nil {
+		return nil
+	}
+	if idx == -1 {
+		removeAstNode(txt)
+	} else {
+		txt.Literal = lit[:idx]
+	}
+	return attr
+}
+
+// isLastParagraphInList returns the list if para is the last
+// block of the last item in a list
+func isLastParagraphInList(para *ast.Paragraph) *ast.List {
+	item, ok := para.Parent.(*ast.ListItem)
+	if !ok || ast.GetNextNode(para) != nil || ast.GetNextNode(item) != nil {
+		return nil
+	}
+	list, _ := item.Parent.(*ast.List)
+	return list
+}
+
+// applyKramdownAttrs finds kramdown block attributes in the document,
+// removes them from the text and attaches to the block they apply to
+func applyKramdownAttrs(doc ast.Node) {
+	var paras []*ast.Paragraph
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if para, ok := node.(*ast.Paragraph); ok && entering {
+			paras = append(paras, para)
+		}
+		return ast.GoToNext
+	})
+
+	for _, para := range paras {
+		attr := kramdownAttrsFromLastLine(para)
+		if attr == nil {
+			continue
+		}
+		if len(para.Children) > 0 {
+			// "text\n{: .foo}" applies to the paragraph, unless
+			// it's a lazy continuation of the last item of a list
+			if list := isLastParagraphInList(para); list != nil {
+				mergeBlockAttribute(list, attr)
+			} else {
+				mergeBlockAttribute(para, attr)
+			}
+			continue
+		}
+		// paragraph consisting only of "{: .foo}" applies to previous block
+		prev := ast.GetPrevNode(para)
+		removeAstNode(para)
+		if prev != nil {
+			mergeBlockAttribute(prev, attr)
+		}
+	}
+}
+
+// parseDataLine parses data-line="1,3-4" attribute into
+// line ranges for chroma's html.HighlightLines
+func parseDataLine(s string) [][2]int {
+	var res [][2]int
+	for _, part := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		start, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		end := start
+		if len(parts) == 2 {
+			end, err = strconv.Atoi(parts[1])
+			if err != nil {
+				continue
+			}
+		}
+		res = append(res, [2]int{start, end})
+	}
+	return res
+}
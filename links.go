@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// cheatsheets imported from devhints link to each other Jekyll-style
+// e.g. "./phoenix", "./moment#formatting", "jest.html" or "/vim"
+// We re-write them to /cheatsheet/${name}.html
+
+// parseCsLink returns name of the cheatsheet and heading id the link points to
+// name is "" for links within the same page, like "#formatting"
+// ok is false if dest is not a link to a cheatsheet
+func parseCsLink(dest string) (name string, headingID string, ok bool) {
+	if strings.Contains(dest, "://") || strings.HasPrefix(dest, "mailto:") {
+		return "", "", false
+	}
+	if idx := strings.Index(dest, "#"); idx != -1 {
+		headingID = dest[idx+1:]
+		dest = dest[:idx]
+	}
+	if dest == "" {
+		return "", headingID, headingID != ""
+	}
+	dest = strings.TrimPrefix(dest, "./")
+	dest = strings.TrimPrefix(dest, "/")
+	if dest == "" || strings.Contains(dest, "/") {
+		return "", "", false
+	}
+	ext := path.Ext(dest)
+	switch ext {
+	case "", ".html", ".md":
+		// those are links to cheatsheets
+	default:
+		return "", "", false
+	}
+	// same as in readCheatSheets(): analytics.js.md => analytics
+	name = strings.Split(dest, ".")[0]
+	return strings.ToLower(name), headingID, true
+}
+
+func csURL(cs *cheatSheet) string {
+	return "/cheatsheet/" + cs.fileNameBase + ".html"
+}
+
+func findCheatsheetByName(cheatsheets []*cheatSheet, name string) *cheatSheet {
+	for _, cs := range cheatsheets {
+		if cs.fileNameBase == name {
+			return cs
+		}
+	}
+	return nil
+}
+
+// findCheatsheetByURL returns cheatsheet for /cheatsheet/${name}.html
+func findCheatsheetByURL(cheatsheets []*cheatSheet, uri string) *cheatSheet {
+	name := strings.TrimPrefix(uri, "/cheatsheet/")
+	if len(name) == len(uri) || !strings.HasSuffix(name, ".html") {
+		return nil
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, ".html"))
+	return findCheatsheetByName(cheatsheets, name)
+}
+
+// resolveCsLinks re-writes links to other cheatsheets to their real urls
+// links to non-existent cheatsheets are left as is
+func resolveCsLinks(doc ast.Node, cheatsheets []*cheatSheet) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		link, ok := node.(*ast.Link)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		name, headingID, ok := parseCsLink(string(link.Destination))
+		if !ok || name == "" {
+			return ast.GoToNext
+		}
+		cs := findCheatsheetByName(cheatsheets, name)
+		if cs == nil {
+			return ast.GoToNext
+		}
+		uri := csURL(cs)
+		if headingID != "" {
+			uri += "#" + headingID
+		}
+		link.Destination = []byte(uri)
+		return ast.GoToNext
+	})
+}
+
+// csHeadingIDs returns ids of all headings in the document
+func csHeadingIDs(doc ast.Node) map[string]bool {
+	res := map[string]bool{}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		if h, ok := node.(*ast.Heading); ok {
+			res[h.HeadingID] = true
+		}
+		if attr := getBlockAttribute(node); attr != nil && attr.ID != nil {
+			res[string(attr.ID)] = true
+		}
+		return ast.GoToNext
+	})
+	return res
+}
+
+// findBrokenLinks returns a description of every link to a non-existent
+// cheatsheet or unknown heading, across all cheatsheets
+func findBrokenLinks(cheatsheets []*cheatSheet) []string {
+	docs := map[*cheatSheet]ast.Node{}
+	headingIDs := map[*cheatSheet]map[string]bool{}
+	for _, cs := range cheatsheets {
+		doc := csParseMarkdown(cs)
+		docs[cs] = doc
+		headingIDs[cs] = csHeadingIDs(doc)
+	}
+
+	var res []string
+	for _, cs := range cheatsheets {
+		ast.WalkFunc(docs[cs], func(node ast.Node, entering bool) ast.WalkStatus {
+			link, ok := node.(*ast.Link)
+			if !ok || !entering {
+				return ast.GoToNext
+			}
+			dest := string(link.Destination)
+			name, headingID, ok := parseCsLink(dest)
+			if !ok {
+				return ast.GoToNext
+			}
+			target := cs
+			if name != "" {
+				target = findCheatsheetByName(cheatsheets, name)
+				if target == nil {
+					res = append(res, fmt.Sprintf("%s: link '%s' to non-existent cheatsheet '%s'", cs.mdPath, dest, name))
+					return ast.GoToNext
+				}
+			}
+			if headingID != "" && !headingIDs[target][headingID] {
+				res = append(res, fmt.Sprintf("%s: link '%s' to unknown heading '#%s' in '%s'", cs.mdPath, dest, headingID, target.mdPath))
+			}
+			return ast.GoToNext
+		})
+	}
+	sort.Strings(res)
+	return res
+}
+
+func checkLinks() {
+	cheatsheets := readCheatSheets()
+	broken := findBrokenLinks(cheatsheets)
+	for _, s := range broken {
+		logf(ctx(), "%s\n", s)
+	}
+	logf(ctx(), "checkLinks: %d broken links in %d cheatsheets\n", len(broken), len(cheatsheets))
+	if len(broken) > 0 {
+		exit(1)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// lint rules, can be individually enabled / disabled with -lint-rules / -lint-disable
+const (
+	lintDuplicateID     = "duplicate-id"
+	lintSkippedLevel    = "skipped-level"
+	lintMissingTitle    = "missing-title"
+	lintMissingCategory = "missing-category"
+	lintLiquidTag       = "liquid-tag"
+	lintUnknownLang     = "unknown-lang"
+	lintEmptySection    = "empty-section"
+	lintParseError      = "parse-error"
+)
+
+var lintAllRules = []string{
+	lintDuplicateID,
+	lintSkippedLevel,
+	lintMissingTitle,
+	lintMissingCategory,
+	lintLiquidTag,
+	lintUnknownLang,
+	lintEmptySection,
+	lintParseError,
+}
+
+type lintIssue struct {
+	path string
+	line int // 1-based, 0 if unknown
+	rule string
+	msg  string
+}
+
+func (i *lintIssue) String() string {
+	return fmt.Sprintf("%s:%d: [%s] %s", i.path, i.line, i.rule, i.msg)
+}
+
+var (
+	rxLintATXHeading = regexp.MustCompile(`^#{1,6}\s`)
+	rxLintSetext     = regexp.MustCompile(`^(=+|-+)\s*$`)
+	rxLintFence      = regexp.MustCompile("^\\s*(```|~~~)\\s*([^\\s`{]*)")
+)
+
+// mdLineInfo is what we need to know about markdown source lines
+// gomarkdown doesn't track positions of ast nodes so we
+// find them by scanning the source
+type mdLineInfo struct {
+	// 1-based line numbers of top-level headings, in order
+	headingLines []int
+	// fenced code blocks: 1-based line number => language
+	fenceLangs map[int]string
+}
+
+// scanMarkdownLines scans lines of markdown. srcLines[i] is line number
+// in the file of i-th line of md (see liquidPreprocess())
+func scanMarkdownLines(md []byte, srcLines []int) *mdLineInfo {
+	res := &mdLineInfo{
+		fenceLangs: map[int]string{},
+	}
+	lines := strings.Split(string(md), "\n")
+	srcLine := func(i int) int {
+		if i >= 0 && i < len(srcLines) {
+			return srcLines[i]
+		}
+		return 0
+	}
+	inFence := ""
+	prevText := false
+	for i, line := range lines {
+		lineNo := srcLine(i)
+
+		if m := rxLintFence.FindStringSubmatch(line); m != nil {
+			if inFence == "" {
+				inFence = m[1]
+				res.fenceLangs[lineNo] = m[2]
+			} else if m[1] == inFence && m[2] == "" {
+				inFence = ""
+			}
+			prevText = false
+			continue
+		}
+		if inFence != "" {
+			continue
+		}
+		switch {
+		case rxLintATXHeading.MatchString(line):
+			res.headingLines = append(res.headingLines, lineNo)
+			prevText = false
+		case prevText && rxLintSetext.MatchString(line):
+			res.headingLines = append(res.headingLines, srcLine(i-1))
+			prevText = false
+		default:
+			prevText = strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "<") && !strings.HasPrefix(line, "|")
+		}
+	}
+	return res
+}
+
+// csHeadingLines returns line numbers of top-level headings in doc
+// parsed from cs. Returns empty map if we can't match them
+func csHeadingLines(cs *cheatSheet, doc ast.Node) map[*ast.Heading]int {
+	info := scanMarkdownLines(cs.md, cs.mdLines)
+	var headings []*ast.Heading
+	for _, n := range doc.GetChildren() {
+		if h, ok := n.(*ast.Heading); ok {
+			headings = append(headings, h)
+		}
+	}
+	res := map[*ast.Heading]int{}
+	if len(info.headingLines) != len(headings) {
+		// scanning source didn't find the same headings as the parser
+		return res
+	}
+	for i, h := range headings {
+		res[h] = info.headingLines[i]
+	}
+	return res
+}
+
+// lintCheatsheet returns all issues in a cheatsheet
+func lintCheatsheet(cs *cheatSheet) []*lintIssue {
+	var res []*lintIssue
+	add := func(line int, rule string, format string, args ...interface{}) {
+		res = append(res, &lintIssue{
+			path: cs.mdPath,
+			line: line,
+			rule: rule,
+			msg:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if cs.err != nil {
+		line, msg := 0, cs.err.Error()
+		if e := asCsError(cs.err); e != nil {
+			line, msg = e.line, e.msg
+		}
+		add(line, lintParseError, "%s", msg)
+		return res
+	}
+	if cs.meta.Title == "" {
+		add(1, lintMissingTitle, "missing 'title' in front matter")
+	}
+	if cs.meta.Category == "" {
+		add(1, lintMissingCategory, "missing 'category' in front matter")
+	}
+
+	info := scanMarkdownLines(cs.md, cs.mdLines)
+
+	for _, w := range cs.liquidWarnings {
+		if w.path == cs.mdPath {
+			add(w.line, lintLiquidTag, "%s", w.msg)
+		} else {
+			// in included file
+			add(w.line, lintLiquidTag, "%s", w)
+		}
+	}
+	for lineNo, lang := range info.fenceLangs {
+		if lang != "" && lexers.Get(lang) == nil {
+			add(lineNo, lintUnknownLang, "unknown language '%s' in fenced code block", lang)
+		}
+	}
+
+	doc := csParseMarkdown(cs)
+	headingLines := csHeadingLines(cs, doc)
+	seenIDs := map[string]int{}
+	prevLevel := 0
+	for _, n := range doc.GetChildren() {
+		h, ok := n.(*ast.Heading)
+		if !ok {
+			continue
+		}
+		line := headingLines[h]
+		text := astText(h)
+		if prevLine, ok := seenIDs[h.HeadingID]; ok {
+			add(line, lintDuplicateID, "duplicate heading id '%s' (first at line %d)", h.HeadingID, prevLine)
+		} else {
+			seenIDs[h.HeadingID] = line
+		}
+		if prevLevel > 0 && h.Level > prevLevel+1 {
+			add(line, lintSkippedLevel, "heading '%s' is h%d but previous heading is h%d", text, h.Level, prevLevel)
+		}
+		prevLevel = h.Level
+
+		next := ast.GetNextNode(h)
+		nextHeading, isHeading := next.(*ast.Heading)
+		if next == nil || (isHeading && nextHeading.Level <= h.Level) {
+			add(line, lintEmptySection, "section '%s' is empty", text)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].line < res[j].line
+	})
+	return res
+}
+
+// parseLintRules returns rules to check, based on comma-separated
+// list of rules to run (all if empty) and rules to disable
+func parseLintRules(enable, disable string) (map[string]bool, error) {
+	isValid := func(rule string) bool {
+		for _, r := range lintAllRules {
+			if r == rule {
+				return true
+			}
+		}
+		return false
+	}
+	splitRules := func(s string) ([]string, error) {
+		var res []string
+		for _, rule := range strings.Split(s, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+			if !isValid(rule) {
+				return nil, fmt.Errorf("unknown lint rule '%s', valid rules: %s", rule, strings.Join(lintAllRules, ", "))
+			}
+			res = append(res, rule)
+		}
+		return res, nil
+	}
+
+	res := map[string]bool{}
+	rules, err := splitRules(enable)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		rules = lintAllRules
+	}
+	for _, rule := range rules {
+		res[rule] = true
+	}
+	rules, err = splitRules(disable)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		delete(res, rule)
+	}
+	return res, nil
+}
+
+func lintCheatsheets(enable, disable string) {
+	rules, err := parseLintRules(enable, disable)
+	if err != nil {
+		logerrf(ctx(), "%s\n", err)
+		exit(2)
+	}
+	cheatsheets := readCheatSheets()
+	sort.Slice(cheatsheets, func(i, j int) bool {
+		return cheatsheets[i].mdPath < cheatsheets[j].mdPath
+	})
+	nIssues := 0
+	byRule := map[string]int{}
+	for _, cs := range cheatsheets {
+		for _, issue := range lintCheatsheet(cs) {
+			if !rules[issue.rule] {
+				continue
+			}
+			fmt.Printf("%s\n", issue)
+			nIssues++
+			byRule[issue.rule]++
+		}
+	}
+	for _, rule := range lintAllRules {
+		if byRule[rule] > 0 {
+			logf(ctx(), "%s: %d\n", rule, byRule[rule])
+		}
+	}
+	logf(ctx(), "lint: %d issues in %d cheatsheets\n", nIssues, len(cheatsheets))
+	if nIssues > 0 {
+		exit(1)
+	}
+}
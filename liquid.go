@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kjk/common/u"
+)
+
+/*
+Cheatsheets imported from devhints are Jekyll pages and use Liquid tags.
+We pre-process markdown and support a subset of Liquid:
+- {% raw %} ... {% endraw %} : content is used verbatim
+- {% include common/foo.md title="Foo" %} : content of cheatsheets/_includes/common/foo.md
+  with {{ include.title }} replaced by "Foo"
+- {% if page.category == 'React' %} ... {% elsif ... %} ... {% else %} ... {% endif %}
+  and {% unless %} over front matter of the cheatsheet. Supports ==, !=, contains,
+  and, or and truthiness of a value
+- {% comment %} ... {% endcomment %} : content is removed without looking at tags
+Other tags are reported and removed from the output.
+
+To keep line numbers in error messages meaningful, lines of removed
+content are preserved as empty lines and we remember line in the source
+of each output line. Lines of included content map to the line with
+{% include %}.
+*/
+
+// directory with partials for {% include %}, relative to csDir
+// readCheatSheets() skips it
+const csIncludesDir = "_includes"
+
+const liquidMaxIncludeDepth = 8
+
+var (
+	rxLiquidTag        = regexp.MustCompile(`\{%-?\s*(\w+)\s*(.*?)\s*-?%\}`)
+	rxLiquidIncludeVar = regexp.MustCompile(`\{\{\s*include\.(\w+)\s*\}\}`)
+	rxLiquidParam      = regexp.MustCompile(`(\w+)\s*=\s*("[^"]*"|'[^']*'|\S+)`)
+	rxLiquidComparison = regexp.MustCompile(`^(.+?)\s*(==|!=|contains)\s*(.+)$`)
+)
+
+// liquidCond is state of {% if %} or {% unless %} block
+type liquidCond struct {
+	tag          string // "if", also for "unless"
+	line         int
+	parentActive bool
+	// true if one of if / elsif branches was taken
+	taken  bool
+	active bool
+}
+
+type liquidPreprocessor struct {
+	path     string
+	vars     map[string]interface{}
+	includes map[string]string // include params, for {{ include.foo }}
+	depth    int
+
+	out bytes.Buffer
+	// outLines[i] is line in the source of i-th line of out
+	outLines []int
+	line     int
+	stack    []*liquidCond
+	warnings []*csError
+}
+
+func (p *liquidPreprocessor) isActive() bool {
+	n := len(p.stack)
+	return n == 0 || p.stack[n-1].active
+}
+
+// emit writes s if we're in active branch, otherwise only its newlines
+func (p *liquidPreprocessor) emit(s string) {
+	p.write(s, p.isActive())
+}
+
+// write writes s from the source, or only its newlines if !keep
+func (p *liquidPreprocessor) write(s string, keep bool) {
+	n := strings.Count(s, "\n")
+	if keep {
+		p.out.WriteString(s)
+	} else {
+		p.out.WriteString(strings.Repeat("\n", n))
+	}
+	for i := 0; i < n; i++ {
+		p.line++
+		p.outLines = append(p.outLines, p.line)
+	}
+}
+
+func (p *liquidPreprocessor) warn(format string, args ...interface{}) {
+	p.warnings = append(p.warnings, newCsError(p.path, p.line, format, args...))
+}
+
+// liquidVarsFromMeta returns values available as page.${name}
+func liquidVarsFromMeta(meta *csMeta) map[string]interface{} {
+	res := map[string]interface{}{}
+	for k, v := range meta.Extra {
+		res[k] = v
+	}
+	res["title"] = meta.Title
+	res["category"] = meta.Category
+	res["tags"] = meta.Tags
+	res["weight"] = meta.Weight
+	res["intro"] = meta.Intro
+	res["keywords"] = meta.Keywords
+	res["description"] = meta.Description
+	if !meta.Updated.IsZero() {
+		res["updated"] = meta.Updated
+	}
+	return res
+}
+
+// liquidValue evaluates an operand of a condition
+func (p *liquidPreprocessor) liquidValue(s string) interface{} {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "nil", "null", "empty", "blank":
+		return nil
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n
+	}
+	if name := strings.TrimPrefix(s, "page."); len(name) != len(s) {
+		return p.vars[name]
+	}
+	if name := strings.TrimPrefix(s, "include."); len(name) != len(s) {
+		if v, ok := p.includes[name]; ok {
+			return v
+		}
+		return nil
+	}
+	// we don't know site.* and other variables
+	p.warn("unsupported variable '%s' in condition", s)
+	return nil
+}
+
+func liquidTruthy(v interface{}) bool {
+	return v != nil && v != false
+}
+
+func liquidEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+}
+
+func liquidContains(a, b interface{}) bool {
+	switch v := a.(type) {
+	case string:
+		s, ok := b.(string)
+		return ok && strings.Contains(v, s)
+	case []string:
+		for _, el := range v {
+			if liquidEqual(el, b) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, el := range v {
+			if liquidEqual(el, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// evalCondition evaluates condition of {% if %}. Like in Liquid,
+// and / or are evaluated right to left without precedence
+func (p *liquidPreprocessor) evalCondition(cond string) bool {
+	words := strings.Fields(cond)
+	for i := len(words) - 1; i > 0; i-- {
+		switch words[i] {
+		case "and":
+			left := p.evalCondition(strings.Join(words[:i], " "))
+			right := p.evalCondition(strings.Join(words[i+1:], " "))
+			return left && right
+		case "or":
+			left := p.evalCondition(strings.Join(words[:i], " "))
+			right := p.evalCondition(strings.Join(words[i+1:], " "))
+			return left || right
+		}
+	}
+	m := rxLiquidComparison.FindStringSubmatch(cond)
+	if m == nil {
+		return liquidTruthy(p.liquidValue(cond))
+	}
+	left, right := p.liquidValue(m[1]), p.liquidValue(m[3])
+	switch m[2] {
+	case "==":
+		return liquidEqual(left, right)
+	case "!=":
+		return !liquidEqual(left, right)
+	}
+	return liquidContains(left, right)
+}
+
+func (p *liquidPreprocessor) include(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return newCsError(p.path, p.line, "{%% include %%} without file name")
+	}
+	name := fields[0]
+	params := map[string]string{}
+	for _, m := range rxLiquidParam.FindAllStringSubmatch(args[len(name):], -1) {
+		v := m[2]
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') {
+			v = v[1 : len(v)-1]
+		} else {
+			v = fmt.Sprintf("%v", p.liquidValue(v))
+		}
+		params[m[1]] = v
+	}
+	if p.depth >= liquidMaxIncludeDepth {
+		return newCsError(p.path, p.line, "includes nested too deeply in '%s'", name)
+	}
+	path := filepath.Join(csDir, csIncludesDir, filepath.FromSlash(name))
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return newCsError(p.path, p.line, "can't include '%s': %s", name, err)
+	}
+	d = normalizeNewlinesInPlace(d)
+	d = rxLiquidIncludeVar.ReplaceAllFunc(d, func(s []byte) []byte {
+		m := rxLiquidIncludeVar.FindSubmatch(s)
+		return []byte(params[string(m[1])])
+	})
+	inc := &liquidPreprocessor{
+		path:     path,
+		vars:     p.vars,
+		includes: params,
+		depth:    p.depth + 1,
+	}
+	err = inc.run(d, 1)
+	p.warnings = append(p.warnings, inc.warnings...)
+	if err != nil {
+		return err
+	}
+	// all included lines map to the line with {% include %}
+	d = inc.out.Bytes()
+	p.out.Write(d)
+	for i := bytes.Count(d, []byte("\n")); i > 0; i-- {
+		p.outLines = append(p.outLines, p.line)
+	}
+	return nil
+}
+
+func (p *liquidPreprocessor) tag(tag string, args string) error {
+	n := len(p.stack)
+	var top *liquidCond
+	if n > 0 {
+		top = p.stack[n-1]
+	}
+	switch tag {
+	case "if", "unless":
+		active := p.isActive()
+		c := &liquidCond{tag: "if", line: p.line, parentActive: active}
+		if active {
+			c.active = p.evalCondition(args)
+			if tag == "unless" {
+				c.active = !c.active
+			}
+			c.taken = c.active
+		}
+		p.stack = append(p.stack, c)
+	case "elsif", "else":
+		if top == nil || top.tag != "if" {
+			return newCsError(p.path, p.line, "{%% %s %%} without {%% if %%}", tag)
+		}
+		top.active = false
+		if top.parentActive && !top.taken {
+			top.active = tag == "else" || p.evalCondition(args)
+			top.taken = top.active
+		}
+	case "endif", "endunless":
+		if top == nil || top.tag != "if" {
+			return newCsError(p.path, p.line, "{%% %s %%} without {%% if %%}", tag)
+		}
+		p.stack = p.stack[:n-1]
+	case "endcomment":
+		return newCsError(p.path, p.line, "{%% endcomment %%} without {%% comment %%}")
+	case "include":
+		if p.isActive() {
+			return p.include(args)
+		}
+	case "endraw":
+		return newCsError(p.path, p.line, "{%% endraw %%} without {%% raw %%}")
+	default:
+		p.warn("unsupported Liquid tag '{%% %s %%}'", tag)
+	}
+	return nil
+}
+
+// skipBlock skips s until {% ${endTag} %} without evaluating tags
+// inside. If keep is true, the content is written to the output
+// Returns the rest of s after end tag
+func (p *liquidPreprocessor) skipBlock(s string, tag string, keep bool) (string, error) {
+	startLine := p.line
+	endTag := "end" + tag
+	for _, m := range rxLiquidTag.FindAllStringSubmatchIndex(s, -1) {
+		if s[m[2]:m[3]] == endTag {
+			p.write(s[:m[0]], keep && p.isActive())
+			return s[m[1]:], nil
+		}
+	}
+	return "", newCsError(p.path, startLine, "{%% %s %%} without {%% %s %%}", tag, endTag)
+}
+
+// run processes md, firstLine is line number of the first line of md in p.path
+func (p *liquidPreprocessor) run(md []byte, firstLine int) error {
+	p.line = firstLine
+	p.outLines = []int{firstLine}
+	s := string(md)
+	for s != "" {
+		loc := rxLiquidTag.FindStringSubmatchIndex(s)
+		if loc == nil {
+			p.emit(s)
+			break
+		}
+		p.emit(s[:loc[0]])
+		tag := s[loc[2]:loc[3]]
+		args := s[loc[4]:loc[5]]
+		s = s[loc[1]:]
+		var err error
+		switch tag {
+		case "raw":
+			s, err = p.skipBlock(s, tag, true)
+		case "comment":
+			s, err = p.skipBlock(s, tag, false)
+		default:
+			err = p.tag(tag, args)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if n := len(p.stack); n > 0 {
+		top := p.stack[n-1]
+		return newCsError(p.path, top.line, "{%% %s %%} without {%% end%s %%}", top.tag, top.tag)
+	}
+	return nil
+}
+
+// liquidPreprocess evaluates Liquid tags in md of a cheatsheet at path
+// firstLine is line number of first line of md in the file
+// Returns processed markdown, line in the file of each of its lines,
+// unsupported tags and fatal errors
+func liquidPreprocess(md []byte, path string, firstLine int, meta *csMeta) ([]byte, []int, []*csError, error) {
+	p := &liquidPreprocessor{
+		path: path,
+		vars: liquidVarsFromMeta(meta),
+	}
+	err := p.run(md, firstLine)
+	if err != nil {
+		return nil, nil, p.warnings, err
+	}
+	return p.out.Bytes(), p.outLines, p.warnings, nil
+}
+
+// liquidIncludesHash returns hash of all files that can be included
+// with {% include %}, for the build cache
+func liquidIncludesHash() string {
+	var a []string
+	dir := filepath.Join(csDir, csIncludesDir)
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			a = append(a, path+":"+fileSha1HexMust(path))
+		}
+		return nil
+	})
+	sort.Strings(a)
+	return u.DataSha1Hex([]byte(strings.Join(a, "\n")))
+}
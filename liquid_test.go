@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLiquidPreprocessLines(t *testing.T) {
+	md := strings.Join([]string{
+		"a",
+		`{% include common/strftime_format.md title="Formats" %}`,
+		"b",
+		"{% comment %}",
+		"{% for x in y %} {% bogus %}",
+		"{% endcomment %}",
+		"c",
+	}, "\n")
+	// as if md started at line 5 of the file, after front matter
+	out, lines, warnings, err := liquidPreprocess([]byte(md), "test.md", 5, &csMeta{})
+	if err != nil {
+		t.Fatalf("liquidPreprocess() failed with '%s'", err)
+	}
+	for _, w := range warnings {
+		t.Errorf("unexpected warning: %s", w)
+	}
+	outLines := strings.Split(string(out), "\n")
+	if len(lines) != len(outLines) {
+		t.Fatalf("got %d line numbers for %d lines", len(lines), len(outLines))
+	}
+	want := map[string]int{
+		"a":             5,
+		"## Formats":    6,
+		"b":             7,
+		"{% bogus %}":   0,
+		"c":             11,
+		"{% comment %}": 0,
+	}
+	for i, s := range outLines {
+		line, ok := want[s]
+		if !ok {
+			continue
+		}
+		if line == 0 {
+			t.Errorf("'%s' should be removed", s)
+			continue
+		}
+		if lines[i] != line {
+			t.Errorf("'%s': got line %d, want %d", s, lines[i], line)
+		}
+		delete(want, s)
+	}
+	for s, line := range want {
+		if line != 0 {
+			t.Errorf("'%s' not in output", s)
+		}
+	}
+}
+
+func TestProcessCheatSheetLinesCRLF(t *testing.T) {
+	md := strings.Join([]string{
+		"---",
+		"title: Test",
+		"---",
+		"",
+		"a",
+		"{% comment %}",
+		"x",
+		"{% endcomment %}",
+		"## b",
+		"",
+	}, "\r\n")
+	path := filepath.Join(t.TempDir(), "test.md")
+	must(os.WriteFile(path, []byte(md), 0644))
+	cs := &cheatSheet{fileNameBase: "test", mdPath: path}
+	if err := processCheatSheet(cs); err != nil {
+		t.Fatalf("processCheatSheet() failed with '%s'", err)
+	}
+	want := map[string]int{
+		"a":    5,
+		"## b": 9,
+	}
+	for i, s := range strings.Split(string(cs.md), "\n") {
+		line, ok := want[s]
+		if !ok {
+			continue
+		}
+		if cs.mdLines[i] != line {
+			t.Errorf("'%s': got line %d, want %d", s, cs.mdLines[i], line)
+		}
+		delete(want, s)
+	}
+	for s := range want {
+		t.Errorf("'%s' not in output", s)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kjk/common/server"
+)
+
+// in dev server we watch cheatsheets/ and www/ directories and when
+// something changes we re-read cheatsheets and tell open pages
+// to reload via Server-Sent Events sent from /dev/reload
+
+const liveReloadURL = "/dev/reload"
+
+// if true, pages include a script that reloads them on changes
+var liveReload bool
+
+// reloadingHandler is a server.Handler whose handlers are
+// replaced after files change
+type reloadingHandler struct {
+	mu       sync.RWMutex
+	handlers []server.Handler
+}
+
+func (h *reloadingHandler) Get(uri string) func(http.ResponseWriter, *http.Request) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, handler := range h.handlers {
+		if send := handler.Get(uri); send != nil {
+			return send
+		}
+	}
+	return nil
+}
+
+func (h *reloadingHandler) URLS() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var res []string
+	for _, handler := range h.handlers {
+		res = append(res, handler.URLS()...)
+	}
+	return res
+}
+
+func (h *reloadingHandler) setHandlers(handlers []server.Handler) {
+	h.mu.Lock()
+	h.handlers = handlers
+	h.mu.Unlock()
+}
+
+// reloadNotifier sends reload event to all pages connected to /dev/reload
+type reloadNotifier struct {
+	mu      sync.Mutex
+	clients map[chan bool]bool
+}
+
+func newReloadNotifier() *reloadNotifier {
+	return &reloadNotifier{
+		clients: map[chan bool]bool{},
+	}
+}
+
+func (n *reloadNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for c := range n.clients {
+		select {
+		case c <- true:
+		default:
+			// already has pending reload
+		}
+	}
+}
+
+func (n *reloadNotifier) serveEvents(w http.ResponseWriter, r *http.Request) {
+	// mainHandler wraps http.ResponseWriter in server.CapturingResponseWriter
+	// which doesn't implement http.Flusher
+	rw := w
+	if cw, ok := w.(*server.CapturingResponseWriter); ok {
+		rw = cw.ResponseWriter
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok || r == nil {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := make(chan bool, 1)
+	n.mu.Lock()
+	n.clients[c] = true
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.clients, c)
+		n.mu.Unlock()
+	}()
+
+	// the stream is open for as long as the page, don't let server's
+	// WriteTimeout cut it
+	err := http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	if err != nil {
+		logWarn(reqCtx(r), "serveEvents: SetWriteDeadline() failed", "err", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-c:
+			fmt.Fprintf(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handler for /dev/reload, not written out by generateStatic
+func (n *reloadNotifier) handler() server.Handler {
+	matches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
+		if uri != liveReloadURL {
+			return nil
+		}
+		return n.serveEvents
+	}
+	urls := func() []string {
+		return nil
+	}
+	return server.NewDynamicHandler(matches, urls)
+}
+
+func watchDirsRecur(watcher *fsnotify.Watcher, dirs ...string) {
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				// called from watcher goroutine for new dirs, which might
+				// already be gone e.g. editor's or git's temporary dirs
+				if err = watcher.Add(path); err != nil {
+					logWarn(ctx(), "watchDirsRecur: watcher.Add() failed", "path", path, "err", err)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// watchForReload calls onChange after files in dirs change
+// changes are batched because editors often write multiple times
+// and calls to onChange are serialized
+func watchForReload(onChange func(), dirs ...string) {
+	watcher, err := fsnotify.NewWatcher()
+	must(err)
+	watchDirsRecur(watcher, dirs...)
+
+	// timer can fire while previous onChange() is still running
+	var mu sync.Mutex
+	reload := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		onChange()
+	}
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				logvf(ctx(), "watchForReload: %s\n", ev)
+				if ev.Op&fsnotify.Create != 0 && dirExists(ev.Name) {
+					watchDirsRecur(watcher, ev.Name)
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(200*time.Millisecond, reload)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logerrf(ctx(), "watchForReload: %s\n", err)
+			}
+		}
+	}()
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,6 +19,10 @@ To enable logging to logdna, set LOGDNA_API_KEY env variable
 
 To enable logging to logtail, set LOGTAIL_API_KEY env variable
 It should be the Authorization header: "Bearer XXX"
+
+To enable logging to a file, set LOG_FILE env variable to its path
+
+See log_ship.go for how logs are sent
 */
 
 const (
@@ -26,117 +34,242 @@ func printLoggingStats() {
 	{
 		apiKey := os.Getenv("LOGDNA_API_KEY")
 		if len(apiKey) < 32 {
-			fmt.Printf("Not logging to logdna because LOGDNA_API_KEY env var not set or invalid\n")
+			logf(ctx(), "Not logging to logdna because LOGDNA_API_KEY env var not set or invalid\n")
 		} else {
-			fmt.Printf("Logging to logdna because LOGDNA_API_KEY env var set\n")
+			logf(ctx(), "Logging to logdna because LOGDNA_API_KEY env var set\n")
 		}
 	}
 	{
 		apiKey := os.Getenv("LOGTAIL_API_KEY")
 		if !strings.HasPrefix(apiKey, "Bearer ") {
-			fmt.Printf("Not logging to logdna because LOGTAIL_API_KEY env var not set or invalid\n")
+			logf(ctx(), "Not logging to logtail because LOGTAIL_API_KEY env var not set or invalid\n")
 		} else {
-			fmt.Printf("Logging to logdna because LOGTAIL_API_KEY env var set\n")
+			logf(ctx(), "Logging to logtail because LOGTAIL_API_KEY env var set\n")
 		}
 	}
+	if path := os.Getenv("LOG_FILE"); path != "" {
+		logf(ctx(), "Logging to '%s' because LOG_FILE env var set\n", path)
+	}
 }
 
-// https://docs.logdna.com/reference#logsingest
-func logdna(s string, now time.Time, isError bool) {
-	// sending logs async because we don't care if fails
-	apiKey := os.Getenv("LOGDNA_API_KEY")
-	if len(apiKey) < 32 {
-		return
-	}
-	go func() {
-		line := map[string]interface{}{
-			"line":      s,
-			"app":       "",
-			"timestamp": now.UnixNano() / 1000000,
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+var (
+	// if true, only errors are printed to stdout
+	// used by terminal commands whose output is the content
+	logQuiet bool
+
+	// messages below this level are not logged. Set with -log-level
+	logMinLevel = logLevelDebug
+
+	// if true, we log JSON, one object per line. Set with -log-format
+	logJSON bool
+)
+
+// setLogOptions sets log level ("debug", "info", "warn", "error")
+// and format ("text" or "json")
+func setLogOptions(level string, format string) error {
+	found := false
+	for i, name := range logLevelNames {
+		if strings.EqualFold(level, name) {
+			logMinLevel = logLevel(i)
+			found = true
 		}
-		if isError {
-			line["level"] = "ERROR"
+	}
+	if !found {
+		return fmt.Errorf("invalid log level '%s', must be one of: %s", level, strings.Join(logLevelNames, ", "))
+	}
+	switch strings.ToLower(format) {
+	case "text":
+		logJSON = false
+	case "json":
+		logJSON = true
+	default:
+		return fmt.Errorf("invalid log format '%s', must be text or json", format)
+	}
+	return nil
+}
+
+type logField struct {
+	key string
+	val interface{}
+}
+
+type logFieldsKey struct{}
+
+// kvToLogFields converts key1, val1, key2, val2 ... to fields
+func kvToLogFields(kv []interface{}) []logField {
+	var res []logField
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 == len(kv) {
+			res = append(res, logField{"!extra", kv[i]})
+			break
 		}
-		lines := []map[string]interface{}{line}
-		v := map[string]interface{}{
-			"lines": lines,
+		res = append(res, logField{fmt.Sprintf("%v", kv[i]), kv[i+1]})
+	}
+	return res
+}
+
+func logFieldsFromCtx(ctx context.Context) []logField {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(logFieldsKey{}).([]logField)
+	return fields
+}
+
+// ctxWithLogFields returns ctx whose log messages include key / value
+// pairs from kv (key1, val1, key2, val2 ...)
+func ctxWithLogFields(ctx context.Context, kv ...interface{}) context.Context {
+	prev := logFieldsFromCtx(ctx)
+	fields := append(prev[:len(prev):len(prev)], kvToLogFields(kv)...)
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+func newRequestID() string {
+	var d [6]byte
+	_, err := rand.Read(d[:])
+	must(err)
+	return hex.EncodeToString(d[:])
+}
+
+func ctxWithRequestID(ctx context.Context, id string) context.Context {
+	return ctxWithLogFields(ctx, "req_id", id)
+}
+
+// reqCtx returns context of a http request. r is nil when
+// generateStatic() calls handlers
+func reqCtx(r *http.Request) context.Context {
+	if r == nil {
+		return ctx()
+	}
+	return r.Context()
+}
+
+func formatLogText(level logLevel, msg string, fields []logField) string {
+	var sb strings.Builder
+	switch level {
+	case logLevelWarn:
+		sb.WriteString("Warning: ")
+	case logLevelError:
+		sb.WriteString("Error: ")
+	}
+	sb.WriteString(strings.TrimSuffix(msg, "\n"))
+	for _, f := range fields {
+		s := fmt.Sprintf("%v", f.val)
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
 		}
-		d, _ := json.Marshal(v)
-		body := strings.NewReader(string(d))
-		c := http.DefaultClient
-		uri := fmt.Sprintf("https://logs.logdna.com/logs/ingest?hostname=%s&apikey=%s", logdnaHost, apiKey)
-		req, err := http.NewRequest("POST", uri, body)
-		if err != nil {
-			return
+		sb.WriteString(" " + f.key + "=" + s)
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func formatLogJSON(now time.Time, level logLevel, msg string, fields []logField) string {
+	var buf bytes.Buffer
+	add := func(key string, val interface{}) {
+		if buf.Len() == 0 {
+			buf.WriteString("{")
+		} else {
+			buf.WriteString(",")
 		}
-		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
-		rsp, err := c.Do(req)
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(val)
 		if err != nil {
-			return
+			v, _ = json.Marshal(fmt.Sprintf("%v", val))
 		}
-		defer rsp.Body.Close()
-	}()
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	add("time", now.UTC().Format(time.RFC3339Nano))
+	add("level", level.String())
+	add("msg", strings.TrimSuffix(msg, "\n"))
+	for _, f := range fields {
+		add(f.key, f.val)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
 }
 
-// https://docs.logtail.com/integrations/rest-api
-func logtail(s string, isError bool) {
-	// sending logs async because we don't care if fails
-	apiKey := os.Getenv("LOGTAIL_API_KEY")
-	if !strings.HasPrefix(apiKey, "Bearer ") {
+// logWrite logs msg with fields from ctx and kv
+// debug messages are only printed, info and above are also sent
+// to logtail, logdna and log file, if enabled
+func logWrite(ctx context.Context, level logLevel, msg string, kv []interface{}) {
+	if level < logMinLevel {
 		return
 	}
+	now := time.Now()
+	fields := append(logFieldsFromCtx(ctx), kvToLogFields(kv)...)
+	var s string
+	if logJSON {
+		s = formatLogJSON(now, level, msg, fields)
+	} else {
+		s = formatLogText(level, msg, fields)
+	}
+	if !logQuiet || level >= logLevelError {
+		fmt.Print(s)
+	}
+	writeAppLog(s)
+	if level >= logLevelInfo {
+		shipLog(&logEntry{
+			time:  now,
+			level: level,
+			line:  strings.TrimSuffix(s, "\n"),
+		})
+	}
+}
 
-	go func() {
-		v := map[string]interface{}{}
-		if isError {
-			v["error"] = s
-		} else {
-			v["message"] = s
-		}
-		d, _ := json.Marshal(v)
-		body := strings.NewReader(string(d))
-		c := http.DefaultClient
-		uri := "https://in.logtail.com/"
-		req, err := http.NewRequest("POST", uri, body)
-		if err != nil {
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", apiKey)
-		rsp, err := c.Do(req)
-		if err != nil {
-			return
-		}
-		defer rsp.Body.Close()
-	}()
+// logDebug, logInfo, logWarn and logError log msg with key / value pairs
+// from kv (key1, val1, key2, val2 ...) and from ctx
+func logDebug(ctx context.Context, msg string, kv ...interface{}) {
+	logWrite(ctx, logLevelDebug, msg, kv)
+}
+
+func logInfo(ctx context.Context, msg string, kv ...interface{}) {
+	logWrite(ctx, logLevelInfo, msg, kv)
+}
+
+func logWarn(ctx context.Context, msg string, kv ...interface{}) {
+	logWrite(ctx, logLevelWarn, msg, kv)
+}
+
+func logError(ctx context.Context, msg string, kv ...interface{}) {
+	logWrite(ctx, logLevelError, msg, kv)
 }
 
 func logf(ctx context.Context, s string, args ...interface{}) {
-	now := time.Now()
 	if len(args) > 0 {
 		s = fmt.Sprintf(s, args...)
 	}
-
-	fmt.Print(s)
-	logtail(s, false)
-	logdna(s, now, false)
+	logWrite(ctx, logLevelInfo, s, nil)
 }
 
-// TODO: write to a file logs/${day}.txt
 func logvf(ctx context.Context, s string, args ...interface{}) {
 	if len(args) > 0 {
 		s = fmt.Sprintf(s, args...)
 	}
-	fmt.Print(s)
+	logWrite(ctx, logLevelDebug, s, nil)
 }
 
 func logerrf(ctx context.Context, format string, args ...interface{}) {
-	now := time.Now()
 	s := format
 	if len(args) > 0 {
 		s = fmt.Sprintf(format, args...)
 	}
-	fmt.Printf("Error: %s", s)
-	logtail(s, true)
-	logdna(s, now, true)
+	logWrite(ctx, logLevelError, s, nil)
 }
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/*
+Log messages at info level and above are sent to logdna, logtail and
+a local file (see printLoggingStats() for how to enable them).
+
+Messages are queued in memory and sent in batches by a single goroutine.
+If the queue is full (e.g. because the ingest endpoint is slow), messages
+are dropped and counted. Failed batches are re-tried with backoff.
+*/
+
+const (
+	logShipQueueSize     = 4096
+	logShipMaxBatch      = 128
+	logShipFlushInterval = 2 * time.Second
+	logShipMaxRetries    = 3
+	logShipRetryDelay    = 500 * time.Millisecond
+	logShipHTTPTimeout   = 10 * time.Second
+	// how long we wait for sending queued messages at exit
+	logShipCloseTimeout = 5 * time.Second
+)
+
+type logEntry struct {
+	time  time.Time
+	level logLevel
+	// formatted message, without trailing newline
+	line string
+}
+
+// logSink sends a batch of log messages somewhere
+type logSink interface {
+	Name() string
+	Send(entries []*logEntry) error
+}
+
+// postJSON sends v as JSON and returns an error on non-2xx response
+func postJSON(client *http.Client, uri string, v interface{}, hdrs map[string]string) error {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", uri, bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, rsp.Body)
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s failed with status %d", rsp.Request.URL.Host, rsp.StatusCode)
+	}
+	return nil
+}
+
+// https://docs.logdna.com/reference#logsingest
+type logdnaSink struct {
+	apiKey string
+	// ingest url, can be changed for testing
+	uri    string
+	client *http.Client
+}
+
+func newLogdnaSink(apiKey string) *logdnaSink {
+	return &logdnaSink{
+		apiKey: apiKey,
+		uri:    "https://logs.logdna.com/logs/ingest",
+		client: &http.Client{Timeout: logShipHTTPTimeout},
+	}
+}
+
+func (s *logdnaSink) Name() string {
+	return "logdna"
+}
+
+func (s *logdnaSink) Send(entries []*logEntry) error {
+	var lines []map[string]interface{}
+	for _, e := range entries {
+		line := map[string]interface{}{
+			"line":      e.line,
+			"app":       logdnaApp,
+			"timestamp": e.time.UnixNano() / 1000000,
+			"level":     strings.ToUpper(e.level.String()),
+		}
+		lines = append(lines, line)
+	}
+	v := map[string]interface{}{
+		"lines": lines,
+	}
+	uri := fmt.Sprintf("%s?hostname=%s&apikey=%s", s.uri, logdnaHost, s.apiKey)
+	return postJSON(s.client, uri, v, nil)
+}
+
+// https://docs.logtail.com/integrations/rest-api
+type logtailSink struct {
+	// value of Authorization header: "Bearer XXX"
+	apiKey string
+	// ingest url, can be changed for testing
+	uri    string
+	client *http.Client
+}
+
+func newLogtailSink(apiKey string) *logtailSink {
+	return &logtailSink{
+		apiKey: apiKey,
+		uri:    "https://in.logtail.com/",
+		client: &http.Client{Timeout: logShipHTTPTimeout},
+	}
+}
+
+func (s *logtailSink) Name() string {
+	return "logtail"
+}
+
+func (s *logtailSink) Send(entries []*logEntry) error {
+	var a []map[string]interface{}
+	for _, e := range entries {
+		v := map[string]interface{}{
+			"dt": e.time.UTC().Format(time.RFC3339Nano),
+		}
+		if e.level >= logLevelError {
+			v["error"] = e.line
+		} else {
+			v["message"] = e.line
+		}
+		a = append(a, v)
+	}
+	hdrs := map[string]string{
+		"Authorization": s.apiKey,
+	}
+	return postJSON(s.client, s.uri, a, hdrs)
+}
+
+// fileLogSink appends log messages to a file
+type fileLogSink struct {
+	path string
+}
+
+func (s *fileLogSink) Name() string {
+	return "file " + s.path
+}
+
+func (s *fileLogSink) Send(entries []*logEntry) error {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.WriteString(e.line)
+		buf.WriteString("\n")
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// logShipper sends log messages to sinks in batches
+type logShipper struct {
+	sinks []logSink
+	queue chan *logEntry
+	quit  chan struct{}
+	done  chan struct{}
+
+	maxBatch      int
+	flushInterval time.Duration
+	retryDelay    time.Duration
+
+	closed   int32
+	nSent    int64
+	nDropped int64
+	nFailed  int64
+}
+
+func newLogShipper(sinks []logSink) *logShipper {
+	s := &logShipper{
+		sinks:         sinks,
+		queue:         make(chan *logEntry, logShipQueueSize),
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+		maxBatch:      logShipMaxBatch,
+		flushInterval: logShipFlushInterval,
+		retryDelay:    logShipRetryDelay,
+	}
+	go s.run()
+	return s
+}
+
+// enqueue never blocks. If the queue is full, e is dropped
+func (s *logShipper) enqueue(e *logEntry) {
+	if atomic.LoadInt32(&s.closed) != 0 {
+		atomic.AddInt64(&s.nDropped, 1)
+		return
+	}
+	select {
+	case s.queue <- e:
+	default:
+		atomic.AddInt64(&s.nDropped, 1)
+	}
+}
+
+// sendWithRetry sends a batch to a sink, re-trying with backoff
+func (s *logShipper) sendWithRetry(sink logSink, batch []*logEntry) error {
+	delay := s.retryDelay
+	var err error
+	for i := 0; i <= logShipMaxRetries; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		err = sink.Send(batch)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *logShipper) send(batch []*logEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	for _, sink := range s.sinks {
+		err := s.sendWithRetry(sink, batch)
+		if err != nil {
+			atomic.AddInt64(&s.nFailed, int64(len(batch)))
+			// printed directly because logging would queue it again
+			fmt.Printf("Error: logShipper: sending %d messages to %s failed with '%s'\n", len(batch), sink.Name(), err)
+			continue
+		}
+		atomic.AddInt64(&s.nSent, int64(len(batch)))
+	}
+}
+
+func (s *logShipper) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	var batch []*logEntry
+	flush := func() {
+		s.send(batch)
+		batch = nil
+	}
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) >= s.maxBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.quit:
+			// send what's left in the queue
+			for {
+				select {
+				case e := <-s.queue:
+					batch = append(batch, e)
+					if len(batch) >= s.maxBatch {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// close sends queued messages, waiting at most timeout
+// returns false if it timed out
+func (s *logShipper) close(timeout time.Duration) bool {
+	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		close(s.quit)
+	}
+	select {
+	case <-s.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// stats returns number of sent, dropped and failed messages
+// sent and failed are counted per sink
+func (s *logShipper) stats() (sent, dropped, failed int64) {
+	return atomic.LoadInt64(&s.nSent), atomic.LoadInt64(&s.nDropped), atomic.LoadInt64(&s.nFailed)
+}
+
+var (
+	logShip     *logShipper
+	logShipOnce sync.Once
+)
+
+// logSinksFromEnv returns sinks enabled with env variables
+func logSinksFromEnv() []logSink {
+	var res []logSink
+	if apiKey := os.Getenv("LOGDNA_API_KEY"); len(apiKey) >= 32 {
+		res = append(res, newLogdnaSink(apiKey))
+	}
+	if apiKey := os.Getenv("LOGTAIL_API_KEY"); strings.HasPrefix(apiKey, "Bearer ") {
+		res = append(res, newLogtailSink(apiKey))
+	}
+	if path := os.Getenv("LOG_FILE"); path != "" {
+		res = append(res, &fileLogSink{path: path})
+	}
+	return res
+}
+
+// getLogShipper returns nil if no sinks are enabled
+func getLogShipper() *logShipper {
+	logShipOnce.Do(func() {
+		if sinks := logSinksFromEnv(); len(sinks) > 0 {
+			logShip = newLogShipper(sinks)
+		}
+	})
+	return logShip
+}
+
+func shipLog(e *logEntry) {
+	if s := getLogShipper(); s != nil {
+		s.enqueue(e)
+	}
+}
+
+// closeLogShipper sends queued log messages. Must be called before exit
+func closeLogShipper() {
+	s := getLogShipper()
+	if s == nil {
+		return
+	}
+	ok := s.close(logShipCloseTimeout)
+	sent, dropped, failed := s.stats()
+	if !ok || dropped > 0 || failed > 0 {
+		fmt.Printf("logShipper: sent %d, dropped %d, failed %d messages, timed out: %v\n", sent, dropped, failed, !ok)
+	}
+}
+
+// exit is os.Exit() that first sends queued log messages
+func exit(code int) {
+	closeLogShipper()
+	os.Exit(code)
+}
+
+// closeLogShipperOnSignal sends queued log messages when we're killed
+// with Ctrl-C or SIGTERM (e.g. when a new version is deployed)
+func closeLogShipperOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		logf(ctx(), "got signal %s, exiting\n", sig)
+		exit(1)
+	}()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ingestServer is a stand-in for logtail ingest endpoint
+type ingestServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	batches []int
+	// first nFail requests fail with 500
+	nFail     int32
+	nRequests int32
+}
+
+func newIngestServer(nFail int) *ingestServer {
+	s := &ingestServer{nFail: int32(nFail)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&s.nRequests, 1)
+		if n <= atomic.LoadInt32(&s.nFail) {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		var a []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.batches = append(s.batches, len(a))
+		s.mu.Unlock()
+	}))
+	return s
+}
+
+func (s *ingestServer) getBatches() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batches...)
+}
+
+// waitForBatches waits until the server received n batches
+func (s *ingestServer) waitForBatches(t *testing.T, n int, timeout time.Duration) []int {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if a := s.getBatches(); len(a) >= n {
+			return a
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("got batches %v, want %d batches", s.getBatches(), n)
+	return nil
+}
+
+func (s *ingestServer) sink() *logtailSink {
+	sink := newLogtailSink("Bearer test")
+	sink.uri = s.URL
+	return sink
+}
+
+// newTestLogShipper is like newLogShipper but with a given queue size
+// and doesn't start sending
+func newTestLogShipper(sinks []logSink, queueSize int, maxBatch int, flushInterval time.Duration) *logShipper {
+	return &logShipper{
+		sinks:         sinks,
+		queue:         make(chan *logEntry, queueSize),
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+		maxBatch:      maxBatch,
+		flushInterval: flushInterval,
+		retryDelay:    10 * time.Millisecond,
+	}
+}
+
+func testLogEntry(s string) *logEntry {
+	return &logEntry{time: time.Now(), level: logLevelInfo, line: s}
+}
+
+func TestLogShipperBatchSize(t *testing.T) {
+	srv := newIngestServer(0)
+	defer srv.Close()
+	s := newTestLogShipper([]logSink{srv.sink()}, 100, 3, time.Hour)
+	go s.run()
+	for i := 0; i < 7; i++ {
+		s.enqueue(testLogEntry("msg"))
+	}
+	// 2 full batches are sent without waiting for flush interval
+	a := srv.waitForBatches(t, 2, 2*time.Second)
+	if a[0] != 3 || a[1] != 3 {
+		t.Fatalf("got batches %v, want [3 3]", a)
+	}
+	if !s.close(time.Second) {
+		t.Fatalf("close() timed out")
+	}
+	a = srv.getBatches()
+	if len(a) != 3 || a[2] != 1 {
+		t.Fatalf("got batches %v, want [3 3 1]", a)
+	}
+	sent, dropped, failed := s.stats()
+	if sent != 7 || dropped != 0 || failed != 0 {
+		t.Fatalf("got sent: %d, dropped: %d, failed: %d, want 7, 0, 0", sent, dropped, failed)
+	}
+}
+
+func TestLogShipperFlushInterval(t *testing.T) {
+	srv := newIngestServer(0)
+	defer srv.Close()
+	s := newTestLogShipper([]logSink{srv.sink()}, 100, 100, 20*time.Millisecond)
+	go s.run()
+	defer s.close(time.Second)
+	s.enqueue(testLogEntry("msg 1"))
+	s.enqueue(testLogEntry("msg 2"))
+	a := srv.waitForBatches(t, 1, 2*time.Second)
+	if a[0] != 2 {
+		t.Fatalf("got batches %v, want [2]", a)
+	}
+}
+
+func TestLogShipperRetry(t *testing.T) {
+	srv := newIngestServer(2)
+	defer srv.Close()
+	s := newTestLogShipper([]logSink{srv.sink()}, 100, 100, time.Hour)
+	timeStart := time.Now()
+	s.send([]*logEntry{testLogEntry("msg")})
+	dur := time.Since(timeStart)
+	if n := atomic.LoadInt32(&srv.nRequests); n != 3 {
+		t.Fatalf("got %d requests, want 3", n)
+	}
+	// backoff: 10 ms, then 20 ms
+	if dur < 30*time.Millisecond {
+		t.Fatalf("retried too fast, in %s", dur)
+	}
+	sent, _, failed := s.stats()
+	if sent != 1 || failed != 0 {
+		t.Fatalf("got sent: %d, failed: %d, want 1, 0", sent, failed)
+	}
+}
+
+func TestLogShipperRetryGivesUp(t *testing.T) {
+	srv := newIngestServer(1000)
+	defer srv.Close()
+	s := newTestLogShipper([]logSink{srv.sink()}, 100, 100, time.Hour)
+	s.send([]*logEntry{testLogEntry("msg 1"), testLogEntry("msg 2")})
+	if n := atomic.LoadInt32(&srv.nRequests); n != logShipMaxRetries+1 {
+		t.Fatalf("got %d requests, want %d", n, logShipMaxRetries+1)
+	}
+	sent, _, failed := s.stats()
+	if sent != 0 || failed != 2 {
+		t.Fatalf("got sent: %d, failed: %d, want 0, 2", sent, failed)
+	}
+}
+
+func TestLogShipperDropsWhenFullAndFlushesOnClose(t *testing.T) {
+	srv := newIngestServer(0)
+	defer srv.Close()
+	s := newTestLogShipper([]logSink{srv.sink()}, 4, 100, time.Hour)
+	// not sending yet so the queue fills up
+	for i := 0; i < 10; i++ {
+		s.enqueue(testLogEntry("msg"))
+	}
+	if _, dropped, _ := s.stats(); dropped != 6 {
+		t.Fatalf("got %d dropped, want 6", dropped)
+	}
+	go s.run()
+	if !s.close(time.Second) {
+		t.Fatalf("close() timed out")
+	}
+	a := srv.getBatches()
+	if len(a) != 1 || a[0] != 4 {
+		t.Fatalf("got batches %v, want [4]", a)
+	}
+	// after close, messages are dropped
+	s.enqueue(testLogEntry("msg"))
+	sent, dropped, _ := s.stats()
+	if sent != 4 || dropped != 7 {
+		t.Fatalf("got sent: %d, dropped: %d, want 4, 7", sent, dropped)
+	}
+}
+
+func TestLogdnaSink(t *testing.T) {
+	var got struct {
+		Lines []map[string]interface{} `json:"lines"`
+	}
+	var apiKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.URL.Query().Get("apikey")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	sink := newLogdnaSink("key")
+	sink.uri = srv.URL
+	err := sink.Send([]*logEntry{testLogEntry("msg 1"), {time: time.Now(), level: logLevelError, line: "msg 2"}})
+	if err != nil {
+		t.Fatalf("Send() failed with '%s'", err)
+	}
+	if apiKey != "key" {
+		t.Fatalf("got apikey '%s', want 'key'", apiKey)
+	}
+	if len(got.Lines) != 2 || got.Lines[1]["line"] != "msg 2" || got.Lines[1]["level"] != "ERROR" {
+		t.Fatalf("got lines %v", got.Lines)
+	}
+}
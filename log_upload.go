@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kjk/minio"
+)
+
+/*
+Rotated http and app logs are uploaded with a logUploader, chosen
+with env variables:
+- LOG_UPLOAD_DIR : copy to a local directory (an archive)
+- LOG_UPLOAD_KEY and LOG_UPLOAD_SECRET (or SPACES_KEY and SPACES_SECRET) :
+  upload to S3-compatible storage configured with LOG_UPLOAD_ENDPOINT,
+  LOG_UPLOAD_BUCKET and LOG_UPLOAD_PREFIX. Defaults to DigitalOcean Spaces
+- otherwise, we don't upload
+
+Files to upload are remembered in logs/.upload_queue.json until they're
+uploaded, so uploads are re-tried after restart.
+*/
+
+const (
+	defaultLogUploadEndpoint = "nyc3.digitaloceanspaces.com"
+	defaultLogUploadBucket   = "kjklogs"
+
+	logUploadQueueName = ".upload_queue.json"
+
+	// retries when uploading a file. If they all fail, we try again
+	// after logUploadRetryInterval
+	logUploadMaxRetries    = 4
+	logUploadRetryDelay    = 2 * time.Second
+	logUploadRetryInterval = 15 * time.Minute
+	// after that many failed rounds of retries we give up
+	logUploadMaxAttempts = 50
+)
+
+// logUploader uploads a log file
+type logUploader interface {
+	Name() string
+	// Upload uploads file at path as remotePath. If remotePath ends
+	// with .br and path doesn't, it's brotli-compressed
+	Upload(remotePath string, path string) error
+}
+
+func needsBrotliCompression(remotePath string, path string) bool {
+	return strings.HasSuffix(remotePath, ".br") && !strings.HasSuffix(path, ".br")
+}
+
+// s3Uploader uploads to S3-compatible storage
+type s3Uploader struct {
+	config *minio.Config
+	// prepended to remote path
+	prefix string
+
+	mu sync.Mutex
+	mc *minio.Client
+}
+
+func (u *s3Uploader) Name() string {
+	return fmt.Sprintf("s3 %s/%s/%s", u.config.Endpoint, u.config.Bucket, u.prefix)
+}
+
+func (u *s3Uploader) client() (*minio.Client, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.mc != nil {
+		return u.mc, nil
+	}
+	mc, err := minio.New(u.config)
+	if err != nil {
+		return nil, err
+	}
+	u.mc = mc
+	return mc, nil
+}
+
+func (u *s3Uploader) Upload(remotePath string, path string) error {
+	mc, err := u.client()
+	if err != nil {
+		return err
+	}
+	remotePath = strings.TrimPrefix(u.prefix+"/"+remotePath, "/")
+	if needsBrotliCompression(remotePath, path) {
+		_, err = mc.UploadFileBrotliCompressedPublic(remotePath, path)
+	} else {
+		_, err = mc.UploadFilePublic(remotePath, path)
+	}
+	return err
+}
+
+// dirUploader copies files to a local directory
+type dirUploader struct {
+	dir string
+}
+
+func (u *dirUploader) Name() string {
+	return "dir " + u.dir
+}
+
+func (u *dirUploader) Upload(remotePath string, path string) error {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if needsBrotliCompression(remotePath, path) {
+		d = brotliCompress(d)
+	}
+	dst := filepath.Join(u.dir, filepath.FromSlash(remotePath))
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename so that we never have partial files
+	tmp := dst + ".tmp"
+	err = os.WriteFile(tmp, d, 0644)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// noopUploader is used when uploading is not configured
+type noopUploader struct{}
+
+func (u *noopUploader) Name() string {
+	return "none"
+}
+
+func (u *noopUploader) Upload(remotePath string, path string) error {
+	return nil
+}
+
+func getenvOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// logUploaderFromEnv returns uploader configured with env variables
+func logUploaderFromEnv() logUploader {
+	if dir := os.Getenv("LOG_UPLOAD_DIR"); dir != "" {
+		return &dirUploader{dir: dir}
+	}
+	access := getenvOr("LOG_UPLOAD_KEY", os.Getenv("SPACES_KEY"))
+	secret := getenvOr("LOG_UPLOAD_SECRET", os.Getenv("SPACES_SECRET"))
+	if access == "" || secret == "" || isWindows() {
+		return &noopUploader{}
+	}
+	return &s3Uploader{
+		config: &minio.Config{
+			Access:   access,
+			Secret:   secret,
+			Bucket:   getenvOr("LOG_UPLOAD_BUCKET", defaultLogUploadBucket),
+			Endpoint: getenvOr("LOG_UPLOAD_ENDPOINT", defaultLogUploadEndpoint),
+		},
+		prefix: strings.Trim(os.Getenv("LOG_UPLOAD_PREFIX"), "/"),
+	}
+}
+
+type logUploadItem struct {
+	Path       string `json:"path"`
+	RemotePath string `json:"remote_path"`
+	Attempts   int    `json:"attempts"`
+}
+
+// logUploadQueue uploads files in the background, one at a time
+// The queue is saved to a file so that it survives restarts
+type logUploadQueue struct {
+	uploader logUploader
+	// where we save the queue
+	path string
+
+	mu    sync.Mutex
+	items []*logUploadItem
+	wake  chan struct{}
+}
+
+func loadLogUploadQueue(path string, uploader logUploader) *logUploadQueue {
+	q := &logUploadQueue{
+		uploader: uploader,
+		path:     path,
+		wake:     make(chan struct{}, 1),
+	}
+	d, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(d, &q.items)
+		if err != nil {
+			logWarn(ctx(), "loadLogUploadQueue: ignoring invalid queue", "path", path, "err", err)
+			q.items = nil
+		}
+	}
+	return q
+}
+
+// must be called with q.mu locked
+func (q *logUploadQueue) saveLocked() {
+	var err error
+	if len(q.items) == 0 {
+		err = os.Remove(q.path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		var d []byte
+		d, err = json.MarshalIndent(q.items, "", "  ")
+		must(err)
+		err = os.WriteFile(q.path, d, 0644)
+	}
+	if err != nil {
+		logerrf(ctx(), "logUploadQueue: saving '%s' failed with '%s'\n", q.path, err)
+	}
+}
+
+func (q *logUploadQueue) add(remotePath string, path string) {
+	if remotePath == "" {
+		logf(ctx(), "logUploadQueue: no remote path for '%s'\n", path)
+		return
+	}
+	q.mu.Lock()
+	// items stay in the queue until uploaded so this also covers
+	// the file being uploaded now
+	for _, it := range q.items {
+		if it.Path == path {
+			q.mu.Unlock()
+			logf(ctx(), "logUploadQueue: '%s' already queued\n", path)
+			return
+		}
+	}
+	q.items = append(q.items, &logUploadItem{
+		Path:       path,
+		RemotePath: remotePath,
+	})
+	q.saveLocked()
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove removes item after it was uploaded or we gave up
+func (q *logUploadQueue) remove(item *logUploadItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, it := range q.items {
+		if it == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	q.saveLocked()
+}
+
+func (q *logUploadQueue) uploadWithRetry(item *logUploadItem) error {
+	delay := logUploadRetryDelay
+	var err error
+	for i := 0; i <= logUploadMaxRetries; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		err = q.uploader.Upload(item.RemotePath, item.Path)
+		if err == nil {
+			return nil
+		}
+		logerrf(ctx(), "logUploadQueue: uploading '%s' to %s failed with '%s'\n", item.Path, q.uploader.Name(), err)
+	}
+	return err
+}
+
+// uploadPending tries to upload all files in the queue
+func (q *logUploadQueue) uploadPending() {
+	q.mu.Lock()
+	items := append([]*logUploadItem(nil), q.items...)
+	q.mu.Unlock()
+
+	for _, item := range items {
+		if !fileExists(item.Path) {
+			// e.g. deleted by removeOldAppLogs()
+			logf(ctx(), "logUploadQueue: '%s' doesn't exist, not uploading\n", item.Path)
+			q.remove(item)
+			continue
+		}
+		timeStart := time.Now()
+		err := q.uploadWithRetry(item)
+		if err == nil {
+			logf(ctx(), "logUploadQueue: uploaded '%s' as '%s' to %s in %s\n", item.Path, item.RemotePath, q.uploader.Name(), time.Since(timeStart))
+			q.remove(item)
+			continue
+		}
+		q.mu.Lock()
+		item.Attempts++
+		giveUp := item.Attempts >= logUploadMaxAttempts
+		q.saveLocked()
+		q.mu.Unlock()
+		if giveUp {
+			logerrf(ctx(), "logUploadQueue: giving up on uploading '%s' after %d attempts\n", item.Path, item.Attempts)
+			q.remove(item)
+		}
+	}
+}
+
+func (q *logUploadQueue) run() {
+	for {
+		q.uploadPending()
+		select {
+		case <-q.wake:
+		case <-time.After(logUploadRetryInterval):
+		}
+	}
+}
+
+var (
+	logUploads     *logUploadQueue
+	logUploadsOnce sync.Once
+)
+
+// startLogUploads starts uploading rotated logs in logs dir, including
+// those that were not uploaded before restart
+func startLogUploads(dir string) {
+	logUploadsOnce.Do(func() {
+		uploader := logUploaderFromEnv()
+		logf(ctx(), "uploading rotated logs to: %s\n", uploader.Name())
+		if _, ok := uploader.(*noopUploader); ok {
+			return
+		}
+		logUploads = loadLogUploadQueue(filepath.Join(dir, logUploadQueueName), uploader)
+		go logUploads.run()
+	})
+}
+
+// canUploadLogs returns true if rotated http and app logs are uploaded
+func canUploadLogs() bool {
+	return logUploads != nil
+}
+
+// queueLogUpload uploads a log file as remotePath in the background.
+// remotePath uses / as separator, like apps/cheatsheets/applog/2021/10-06.txt.br
+func queueLogUpload(remotePath string, path string) {
+	if logUploads == nil {
+		return
+	}
+	logUploads.add(remotePath, path)
+}
@@ -20,19 +20,66 @@ func main() {
 		flgRunServer     bool
 		flgRunServerProd bool
 		flgGen           bool
+		flgGenFull       bool
+		flgSkipBroken    bool
+		flgGenPrint      bool
 		flgDeploy        bool
+		flgCheckLinks    bool
+		flgLint          bool
+		flgLintRules     string
+		flgLintDisable   string
+		flgSizeReport    bool
+		flgSizeReportOut string
+		flgSizeReportCmp string
+		flgLogLevel      string
+		flgLogFormat     string
+		flgLogCompress   bool
+		flgStats         bool
+		flgStatsDir      string
+		flgStatsFrom     string
+		flgStatsTo       string
+		flgStatsFormat   string
+		flgStatsTop      int
 	)
 	{
 		flag.BoolVar(&flgRunServer, "run", false, "run dev server")
 		flag.BoolVar(&flgRunServerProd, "run-prod", false, "run prod server serving www_generated")
 		flag.BoolVar(&flgGen, "gen", false, "generate static files in www_generated dir")
+		flag.BoolVar(&flgGenFull, "gen-full", false, "re-generate all static files in www_generated dir, ignoring build cache")
+		flag.BoolVar(&flgSkipBroken, "skip-broken", false, "with -gen, skip cheatsheets with errors instead of failing")
+		flag.BoolVar(&flgGenPrint, "gen-print", false, "with -gen, also generate print-optimized pages /cheatsheet/${name}.print.html")
 		flag.BoolVar(&flgDeploy, "deploy", false, "deploy to render.com")
+		flag.BoolVar(&flgCheckLinks, "check-links", false, "report links to non-existent cheatsheets and headings")
+		flag.BoolVar(&flgLint, "lint", false, "report problems in cheatsheet markdown files")
+		flag.StringVar(&flgLintRules, "lint-rules", "", "comma-separated lint rules to check (default: all)")
+		flag.StringVar(&flgLintDisable, "lint-disable", "", "comma-separated lint rules to not check")
+		flag.BoolVar(&flgSizeReport, "size-report", false, "report raw and compressed size of generated pages")
+		flag.StringVar(&flgSizeReportOut, "size-report-out", "", "with -size-report, save the report as JSON to this file")
+		flag.StringVar(&flgSizeReportCmp, "size-report-cmp", "", "with -size-report, compare with report in this JSON file and fail on size regressions")
+		flag.StringVar(&flgLogLevel, "log-level", "debug", "log messages at this level and above: debug, info, warn or error")
+		flag.StringVar(&flgLogFormat, "log-format", "text", "log format: text or json")
+		flag.BoolVar(&flgLogCompress, "log-compress", true, "compress daily app log files in logs dir after rotating")
+		flag.BoolVar(&flgStats, "stats", false, "report stats from http logs")
+		flag.StringVar(&flgStatsDir, "stats-dir", "logs", "with -stats, directory with httplog-*.txt[.br] files")
+		flag.StringVar(&flgStatsFrom, "stats-from", "", "with -stats, first day (YYYY-MM-DD), default: 6 days before -stats-to")
+		flag.StringVar(&flgStatsTo, "stats-to", "", "with -stats, last day (YYYY-MM-DD), default: today")
+		flag.StringVar(&flgStatsFormat, "stats-format", "text", "with -stats, output format: text or json")
+		flag.IntVar(&flgStatsTop, "stats-top", 20, "with -stats, how many top cheatsheets, referrers and 404s to show")
 		flag.Parse()
 	}
 
-	if false {
-		compareCompr()
-		return
+	if err := setLogOptions(flgLogLevel, flgLogFormat); err != nil {
+		logerrf(ctx(), "%s\n", err)
+		flag.Usage()
+		exit(1)
+	}
+	appLogCompress = flgLogCompress
+	defer closeLogShipper()
+	closeLogShipperOnSignal()
+
+	// cheatsheets show <name> [section], cheatsheets search <term>
+	if args := flag.Args(); len(args) > 0 {
+		exit(runTermCommand(args))
 	}
 
 	if flgRunServer {
@@ -45,8 +92,30 @@ func main() {
 		return
 	}
 
-	if flgGen {
-		generateStatic()
+	if flgGen || flgGenFull || flgGenPrint {
+		genPrint = flgGenPrint
+		generateStatic(flgGenFull, flgSkipBroken)
+		return
+	}
+
+	if flgSizeReport {
+		genPrint = flgGenPrint
+		exit(runSizeReport(flgSizeReportOut, flgSizeReportCmp))
+	}
+
+	if flgStats {
+		// json output is the content
+		logQuiet = flgStatsFormat == "json"
+		exit(runStats(flgStatsDir, flgStatsFrom, flgStatsTo, flgStatsFormat, flgStatsTop))
+	}
+
+	if flgLint {
+		lintCheatsheets(flgLintRules, flgLintDisable)
+		return
+	}
+
+	if flgCheckLinks {
+		checkLinks()
 		return
 	}
 
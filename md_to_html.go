@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	htmlstd "html"
 	"io"
+	"sort"
+	"strings"
 
 	"github.com/alecthomas/chroma"
 	"github.com/alecthomas/chroma/formatters/html"
@@ -26,8 +30,64 @@ func init() {
 
 }
 
+// attrsPreWrapper adds kramdown block attributes to chroma's <pre> element
+// They come from markdown so, like gomarkdown's BlockAttrs, we escape them
+type attrsPreWrapper struct {
+	attr *ast.Attribute
+}
+
+func (p attrsPreWrapper) Start(code bool, styleAttr string) string {
+	var classes []string
+	for _, c := range p.attr.Classes {
+		classes = append(classes, htmlstd.EscapeString(string(c)))
+	}
+	if len(classes) > 0 {
+		cls := strings.Join(classes, " ")
+		if strings.Contains(styleAttr, `class="`) {
+			styleAttr = strings.Replace(styleAttr, `class="`, `class="`+cls+" ", 1)
+		} else {
+			styleAttr += fmt.Sprintf(` class="%s"`, cls)
+		}
+	}
+	s := `<pre tabindex="0"` + styleAttr
+	if p.attr.ID != nil {
+		s += fmt.Sprintf(` id="%s"`, htmlstd.EscapeString(string(p.attr.ID)))
+	}
+	var keys []string
+	for k := range p.attr.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s += fmt.Sprintf(` %s="%s"`, htmlstd.EscapeString(k), htmlstd.EscapeString(string(p.attr.Attrs[k])))
+	}
+	return s + ">"
+}
+
+func (p attrsPreWrapper) End(code bool) string {
+	return "</pre>"
+}
+
+// formatterForAttrs returns html formatter that highlights lines from
+// data-line attribute and adds attributes to <pre>
+func formatterForAttrs(attr *ast.Attribute) *html.Formatter {
+	if attr == nil {
+		return htmlFormatter
+	}
+	opts := []html.Option{
+		html.WithClasses(true),
+		html.TabWidth(2),
+		html.WithPreWrapper(attrsPreWrapper{attr: attr}),
+	}
+	if dataLine := attr.Attrs["data-line"]; len(dataLine) > 0 {
+		opts = append(opts, html.HighlightLines(parseDataLine(string(dataLine))))
+	}
+	return html.New(opts...)
+}
+
 // based on https://github.com/alecthomas/chroma/blob/master/quick/quick.go
-func htmlHighlight(w io.Writer, source, lang, defaultLang string) error {
+// attr are optional kramdown block attributes of the code block
+func htmlHighlight(w io.Writer, source, lang, defaultLang string, attr *ast.Attribute) error {
 	if lang == "" {
 		lang = defaultLang
 	}
@@ -44,7 +104,7 @@ func htmlHighlight(w io.Writer, source, lang, defaultLang string) error {
 	if err != nil {
 		return err
 	}
-	return htmlFormatter.Format(w, highlightStyle, it)
+	return formatterForAttrs(attr).Format(w, highlightStyle, it)
 }
 
 func makeRenderHookCodeBlock(defaultLang string) mdhtml.RenderNodeFunc {
@@ -60,7 +120,7 @@ func makeRenderHookCodeBlock(defaultLang string) mdhtml.RenderNodeFunc {
 			mdhtml.EscapeHTML(w, codeBlock.Literal)
 			io.WriteString(w, "</code></pre>\n")
 		} else {
-			htmlHighlight(w, string(codeBlock.Literal), lang, defaultLang)
+			htmlHighlight(w, string(codeBlock.Literal), lang, defaultLang, codeBlock.Attribute)
 		}
 		return ast.GoToNext, true
 	}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// generateStatic() writes pre-compressed foo.html.br and foo.html.gz
+// next to foo.html so that the prod server doesn't have to compress
+// on the fly
+
+const (
+	// files smaller than that are not worth compressing
+	precompressMinSize = 1024
+	// brotli.BestCompression (11) is ~10x slower for ~1% smaller files
+	brotliLevel = 9
+)
+
+func gzipCompress(d []byte) []byte {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	must(err)
+	_, err = w.Write(d)
+	must(err)
+	must(w.Close())
+	return buf.Bytes()
+}
+
+func brotliCompress(d []byte) []byte {
+	return brotliCompressLevel(d, brotliLevel)
+}
+
+func brotliCompressLevel(d []byte, level int) []byte {
+	var buf bytes.Buffer
+	w := brotli.NewWriterLevel(&buf, level)
+	_, err := w.Write(d)
+	must(err)
+	must(w.Close())
+	return buf.Bytes()
+}
+
+// comprStats are total sizes and compression times of a set of files
+type comprStats struct {
+	mu       sync.Mutex
+	nFiles   int
+	origSize int64
+	gzSize   int64
+	gzDur    time.Duration
+	brSize   int64
+	brDur    time.Duration
+	// number of .gz and .br not written because they didn't shrink
+	nSkipped int
+}
+
+func (s *comprStats) log(what string) {
+	logf(ctx(), "%s: %d files\n", what, s.nFiles)
+	if s.origSize == 0 {
+		return
+	}
+	logf(ctx(), "un: %d %s\n", s.origSize, formatSize(s.origSize))
+	logf(ctx(), "gz: %d %s in %s %.2f%%\n", s.gzSize, formatSize(s.gzSize), s.gzDur, perc(s.origSize, s.gzSize))
+	logf(ctx(), "br: %d %s in %s %.2f%%\n", s.brSize, formatSize(s.brSize), s.brDur, perc(s.origSize, s.brSize))
+	if s.nSkipped > 0 {
+		logf(ctx(), "skipped %d compressed files that were not smaller\n", s.nSkipped)
+	}
+}
+
+// canPrecompress returns true if we should write .br and .gz for
+// a file of a given size
+func canPrecompress(path string, size int64) bool {
+	if size < precompressMinSize {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".html", ".css", ".js", ".json", ".xml":
+		return true
+	}
+	return false
+}
+
+// hasPrecompressed returns true if both .br and .gz exist for path
+func hasPrecompressed(path string) bool {
+	return fileExists(path+".br") && fileExists(path+".gz")
+}
+
+func removePrecompressed(path string) {
+	for _, ext := range []string{".br", ".gz"} {
+		err := os.Remove(path + ext)
+		if err != nil && !os.IsNotExist(err) {
+			logerrf(ctx(), "removePrecompressed: os.Remove('%s') failed with '%s'\n", path+ext, err)
+		}
+	}
+}
+
+// writeIfSmaller writes compressed version of a file unless it's not
+// smaller than the original, in which case it removes stale version
+func writeIfSmaller(path string, orig []byte, compr []byte) bool {
+	if len(compr) >= len(orig) {
+		os.Remove(path)
+		return false
+	}
+	must(os.WriteFile(path, compr, 0644))
+	return true
+}
+
+func precompressFile(path string, stats *comprStats) {
+	d, err := os.ReadFile(path)
+	must(err)
+
+	timeStart := time.Now()
+	gz := gzipCompress(d)
+	gzDur := time.Since(timeStart)
+	timeStart = time.Now()
+	br := brotliCompress(d)
+	brDur := time.Since(timeStart)
+
+	nSkipped := 0
+	if !writeIfSmaller(path+".gz", d, gz) {
+		nSkipped++
+	}
+	if !writeIfSmaller(path+".br", d, br) {
+		nSkipped++
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.nFiles++
+	stats.origSize += int64(len(d))
+	stats.gzSize += int64(len(gz))
+	stats.gzDur += gzDur
+	stats.brSize += int64(len(br))
+	stats.brDur += brDur
+	stats.nSkipped += nSkipped
+}
+
+// precompressFiles writes .br and .gz versions of files in parallel
+func precompressFiles(paths []string) *comprStats {
+	stats := &comprStats{}
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range ch {
+				precompressFile(path, stats)
+			}
+		}()
+	}
+	for _, path := range paths {
+		ch <- path
+	}
+	close(ch)
+	wg.Wait()
+	return stats
+}
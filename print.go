@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aymerick/raymond"
+	"github.com/gomarkdown/markdown"
+	"github.com/kjk/common/server"
+)
+
+// print-optimized render of a cheatsheet at /cheatsheet/${name}.print.html
+// It has no toc or search and is laid out in multiple columns to fit on
+// A4 or Letter pages. The only JavaScript switches paper size (?paper=letter)
+// Use "Print" / "Save as PDF" in the browser
+
+// if true, generateStatic() also writes print pages and cheatsheet
+// pages link to them. Set with -gen-print, always true in dev server
+var genPrint bool
+
+func csPrintURL(cs *cheatSheet) string {
+	return "/cheatsheet/" + cs.fileNameBase + ".print.html"
+}
+
+func genCheatsheetPrintHTML(ctx context.Context, cs *cheatSheet, cheatsheets []*cheatSheet) ([]byte, error) {
+	logInfo(ctx, "genCheatsheetPrintHTML", "path", cs.mdPath)
+	if cs.err != nil {
+		return nil, cs.err
+	}
+	doc := csParseMarkdown(cs)
+	resolveCsLinks(doc, cheatsheets)
+	// we don't use the toc but it validates and assigns heading ids
+	_, err := csBuildToc(doc, cs)
+	if err != nil {
+		return nil, err
+	}
+	renderer := newMarkdownHTMLRenderer("")
+	mdHTML := string(markdown.Render(doc, renderer))
+
+	tplPath := filepath.Join(csTmplDir, "cheatsheet.print.tmpl.html")
+	tpl, err := os.ReadFile(tplPath)
+	if err != nil {
+		return nil, err
+	}
+	tplCtx := map[string]interface{}{
+		"title":         cs.Title,
+		"url":           siteURL + csURL(cs),
+		"content":       mdHTML,
+		"wip":           csHasTag(cs, csTagWIP),
+		"liveReload":    liveReload,
+		"liveReloadURL": liveReloadURL,
+	}
+	s, err := raymond.Render(string(tpl), tplCtx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", tplPath, err)
+	}
+	return []byte(s), nil
+}
+
+// buildContentPrint returns handler for /cheatsheet/${name}.print.html
+// pages. They're always served but only generated if genPrint is true
+func buildContentPrint(cheatsheets []*cheatSheet) []server.Handler {
+	matches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
+		// match /cheatsheet/go.print.html => go
+		name := strings.TrimPrefix(uri, "/cheatsheet/")
+		if len(name) == len(uri) || !strings.HasSuffix(name, ".print.html") {
+			return nil
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, ".print.html"))
+		cs := findCheatsheetByName(cheatsheets, name)
+		if cs == nil {
+			return nil
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			cs := reprocessCheatSheet(cs)
+			html, err := genCheatsheetPrintHTML(reqCtx(r), cs, cheatsheets)
+			if err != nil {
+				serveCsRenderError(w, r, cs, err)
+				return
+			}
+			server.MakeServeContent(uri, html)(w, r)
+		}
+	}
+	urls := func() []string {
+		if !genPrint {
+			return nil
+		}
+		var res []string
+		for _, cs := range cheatsheets {
+			res = append(res, csPrintURL(cs))
+		}
+		return res
+	}
+	return []server.Handler{server.NewDynamicHandler(matches, urls)}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+/*
+Full-text search across all cheatsheets.
+
+The index is built from headings, prose and code of every cheatsheet.
+A unit of search is a section i.e. content under a heading.
+
+For the static site the index is written as:
+/search/docs.json : {"sheets": [[name, title, isGood], ...], "sections": [[sheetIdx, headingID, heading], ...]}
+/search/index-${c}.json : {"term": [sectionIdx, weight, sectionIdx, weight, ...], ...}
+where ${c} is the first letter of the term (or "_" for other characters).
+
+Dynamic server serves /api/search?q=${query}
+*/
+
+const (
+	searchWeightHeading = 10
+	searchWeightProse   = 2
+	searchWeightCode    = 1
+
+	searchMaxResults = 50
+)
+
+type searchSection struct {
+	cs        *cheatSheet
+	headingID string
+	heading   string
+}
+
+type searchPosting struct {
+	section int
+	weight  int
+}
+
+type fullTextIndex struct {
+	sheets   []*cheatSheet
+	sections []*searchSection
+	terms    map[string][]searchPosting
+}
+
+type searchResult struct {
+	Name      string `json:"name"`
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	HeadingID string `json:"headingID,omitempty"`
+	Heading   string `json:"heading,omitempty"`
+	Score     int    `json:"score"`
+}
+
+// searchTokenize splits s into lower-case words, ignoring 1-letter words
+func searchTokenize(s string) []string {
+	isSep := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}
+	var res []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), isSep) {
+		if len(w) > 1 {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+func astText(node ast.Node) string {
+	var sb strings.Builder
+	ast.WalkFunc(node, func(n ast.Node, entering bool) ast.WalkStatus {
+		if entering {
+			if l := n.AsLeaf(); l != nil {
+				sb.Write(l.Literal)
+			}
+		}
+		return ast.GoToNext
+	})
+	return sb.String()
+}
+
+// isGoodCheatsheet returns true for cheatsheets that should rank higher
+func isGoodCheatsheet(cs *cheatSheet) bool {
+	return cs.status == csStatusPublished
+}
+
+func buildFullTextIndex(cheatsheets []*cheatSheet) *fullTextIndex {
+	idx := &fullTextIndex{
+		terms: map[string][]searchPosting{},
+	}
+	for _, cs := range cheatsheets {
+		idx.addCheatsheet(cs)
+	}
+	logf(ctx(), "buildFullTextIndex: %d cheatsheets, %d sections, %d terms\n", len(idx.sheets), len(idx.sections), len(idx.terms))
+	return idx
+}
+
+func (idx *fullTextIndex) addCheatsheet(cs *cheatSheet) {
+	idx.sheets = append(idx.sheets, cs)
+	doc := csParseMarkdown(cs)
+
+	// term => weight within current section
+	var weights map[string]int
+	addTerms := func(s string, weight int) {
+		for _, term := range searchTokenize(s) {
+			weights[term] += weight
+		}
+	}
+	flush := func() {
+		sectionIdx := len(idx.sections) - 1
+		for term, weight := range weights {
+			idx.terms[term] = append(idx.terms[term], searchPosting{sectionIdx, weight})
+		}
+	}
+	startSection := func(headingID, heading string) {
+		if weights != nil {
+			flush()
+		}
+		idx.sections = append(idx.sections, &searchSection{
+			cs:        cs,
+			headingID: headingID,
+			heading:   heading,
+		})
+		weights = map[string]int{}
+	}
+
+	// title, front matter and content before first heading
+	startSection("", cs.Title)
+	addTerms(cs.Title, searchWeightHeading)
+	addTerms(strings.Join(cs.meta.Keywords, " "), searchWeightProse)
+	addTerms(cs.meta.Description, searchWeightProse)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch v := node.(type) {
+		case *ast.Heading:
+			heading := astText(v)
+			startSection(v.HeadingID, heading)
+			addTerms(heading, searchWeightHeading)
+			return ast.SkipChildren
+		case *ast.CodeBlock:
+			addTerms(string(v.Literal), searchWeightCode)
+		case *ast.Code:
+			addTerms(string(v.Literal), searchWeightCode)
+		case *ast.Text:
+			addTerms(string(v.Literal), searchWeightProse)
+		}
+		return ast.GoToNext
+	})
+	flush()
+}
+
+// search returns sections that contain all words in q, best matches first
+func (idx *fullTextIndex) search(q string, maxResults int) []*searchResult {
+	terms := searchTokenize(q)
+	if len(terms) == 0 {
+		return nil
+	}
+	// section index => score
+	scores := map[int]int{}
+	for i, term := range terms {
+		matched := map[int]int{}
+		for _, p := range idx.terms[term] {
+			if i == 0 {
+				matched[p.section] = p.weight
+				continue
+			}
+			if score, ok := scores[p.section]; ok {
+				matched[p.section] = score + p.weight
+			}
+		}
+		scores = matched
+	}
+
+	var res []*searchResult
+	for sectionIdx, score := range scores {
+		section := idx.sections[sectionIdx]
+		cs := section.cs
+		if isGoodCheatsheet(cs) {
+			score = score * 3 / 2
+		}
+		uri := csURL(cs)
+		if section.headingID != "" {
+			uri += "?" + section.headingID
+		}
+		res = append(res, &searchResult{
+			Name:      cs.fileNameBase,
+			Title:     cs.Title,
+			URL:       uri,
+			HeadingID: section.headingID,
+			Heading:   section.heading,
+			Score:     score,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].URL < res[j].URL
+	})
+	if len(res) > maxResults {
+		res = res[:maxResults]
+	}
+	return res
+}
+
+func searchShardName(term string) string {
+	c := term[0]
+	if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+		return string(c)
+	}
+	return "_"
+}
+
+// shards returns static json files with the index, keyed by url
+func (idx *fullTextIndex) shards() map[string][]byte {
+	res := map[string][]byte{}
+
+	sheetIdx := map[*cheatSheet]int{}
+	sheets := [][]interface{}{}
+	for i, cs := range idx.sheets {
+		sheetIdx[cs] = i
+		isGood := 0
+		if isGoodCheatsheet(cs) {
+			isGood = 1
+		}
+		sheets = append(sheets, []interface{}{cs.fileNameBase, cs.Title, isGood})
+	}
+	sections := [][]interface{}{}
+	for _, s := range idx.sections {
+		sections = append(sections, []interface{}{sheetIdx[s.cs], s.headingID, s.heading})
+	}
+	d, err := json.Marshal(map[string]interface{}{
+		"sheets":   sheets,
+		"sections": sections,
+	})
+	must(err)
+	res["/search/docs.json"] = d
+
+	byShard := map[string]map[string][]int{}
+	for term, postings := range idx.terms {
+		name := searchShardName(term)
+		shard := byShard[name]
+		if shard == nil {
+			shard = map[string][]int{}
+			byShard[name] = shard
+		}
+		a := make([]int, 0, len(postings)*2)
+		for _, p := range postings {
+			a = append(a, p.section, p.weight)
+		}
+		shard[term] = a
+	}
+	for name, shard := range byShard {
+		d, err := json.Marshal(shard)
+		must(err)
+		res["/search/index-"+name+".json"] = d
+	}
+	return res
+}
+
+func serveSearch(w http.ResponseWriter, r *http.Request, idx *fullTextIndex) {
+	q := r.URL.Query().Get("q")
+	results := idx.search(q, searchMaxResults)
+	if results == nil {
+		results = []*searchResult{}
+	}
+	v := map[string]interface{}{
+		"query":   q,
+		"results": results,
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	must(json.NewEncoder(w).Encode(v))
+}
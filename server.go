@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kjk/common/server"
@@ -33,9 +36,15 @@ func makeHTTPServer(srv *server.Server) *http.Server {
 		timeStart := time.Now()
 		cw := server.CapturingResponseWriter{ResponseWriter: w}
 
+		// handlers get request id with reqCtx(r) and log it
+		reqID := newRequestID()
+		ctx := ctxWithRequestID(r.Context(), reqID)
+		r = r.WithContext(ctx)
+		cw.Header().Set("X-Request-ID", reqID)
+
 		defer func() {
 			if p := recover(); p != nil {
-				logf(ctx(), "mainHandler: panicked with with %v\n", p)
+				logError(ctx, "mainHandler: panicked", "panic", p)
 				http.Error(&cw, fmt.Sprintf("Error: %v", p), http.StatusInternalServerError)
 			}
 			logHTTPReq(r, cw.StatusCode, cw.Size, time.Since(timeStart))
@@ -60,38 +69,48 @@ func makeHTTPServer(srv *server.Server) *http.Server {
 	return httpSrv
 }
 
-func buildContentCheatsheets() []server.Handler {
-	cheatsheets := readCheatSheets()
-	csFindByURL := func(uri string) *cheatSheet {
+func buildContentCheatsheets(cheatsheets []*cheatSheet) []server.Handler {
+	categoriesCfg := loadCategoriesConfig()
+	// category and tag pages, like /all.html, don't list drafts and obsolete
+	listed := filterCsListedIn(cheatsheets, "/all.html")
+	csFindByURL := func(ctx context.Context, uri string) *cheatSheet {
 		// match /cheatsheet/go.html => go
 		uriBase := strings.ToLower(strings.TrimPrefix(uri, "/cheatsheet/"))
 		if len(uri) == len(uriBase) {
 			// doesn't start with /cheatsheet
-			logvf(ctx(), "csFindByURL: no match for '%s because doesn't start with /cheatsheet/'\n", uri)
+			logDebug(ctx, "csFindByURL: no match", "uri", uri, "reason", "doesn't start with /cheatsheet/")
 			return nil
 		}
 		uriBaseNoExt := strings.TrimSuffix(uriBase, ".html")
 		if len(uriBase) == len(uriBaseNoExt) {
 			// doens't end with .html
-			logf(ctx(), "csFindByURL: no match for '%s' because doesn't end with .html\n", uri)
+			logDebug(ctx, "csFindByURL: no match", "uri", uri, "reason", "doesn't end with .html")
 			return nil
 		}
-		logvf(ctx(), "csFindByURL: looking for '%s'\n", uriBaseNoExt)
 		for _, cs := range cheatsheets {
 			if uriBaseNoExt == cs.fileNameBase {
-				logvf(ctx(), "csFindByURL: found match for '%s'\n", uri)
+				logDebug(ctx, "csFindByURL: found match", "uri", uri, "name", uriBaseNoExt)
 				return cs
 			}
 		}
-		logvf(ctx(), "csFindByURL: no match for '%s'\n", uri)
+		logDebug(ctx, "csFindByURL: no match", "uri", uri, "name", uriBaseNoExt)
 		return nil
 	}
 	csMatches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
-		cs := csFindByURL(uri)
+		// we don't have a request yet, csFindByURL() logs in send
+		if findCheatsheetByURL(cheatsheets, uri) == nil {
+			return nil
+		}
 		send := func(w http.ResponseWriter, r *http.Request) {
+			ctx := reqCtx(r)
+			cs := csFindByURL(ctx, uri)
 			panicIf(cs == nil, "no match for '%s'", uri)
-			processCheatSheet(cs)
-			html := genCheatsheetHTML(cs)
+			cs = reprocessCheatSheet(cs)
+			html, err := genCheatsheetHTML(ctx, cs, cheatsheets)
+			if err != nil {
+				serveCsRenderError(w, r, cs, err)
+				return
+			}
 			if r == nil {
 				w.Write(html)
 				return
@@ -99,40 +118,28 @@ func buildContentCheatsheets() []server.Handler {
 			content := bytes.NewReader(html)
 			http.ServeContent(w, r, "foo.html", time.Time{}, content)
 		}
-		if cs == nil {
-			return nil
-		}
 		return send
 	}
 	csURLS := func() []string {
 		var res []string
 		for _, cs := range cheatsheets {
-			uri := "/cheatsheet/" + cs.fileNameBase + ".html"
-			res = append(res, uri)
+			res = append(res, csURL(cs))
 		}
 		return res
 	}
 
 	csIndexMatches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
 		switch uri {
-		case "/index.html", "/all.html":
+		case "/index.html", "/all.html", "/drafts.html":
 			// no-op
 		default:
 			return nil
 		}
-		all := uri == "/all.html"
 		send := func(w http.ResponseWriter, r *http.Request) {
-			logf(ctx(), "csIndexSend: '%s'\n", uri)
-			a := cheatsheets
-			if !all {
-				a = nil
-				for _, cs := range cheatsheets {
-					if cs.inMain {
-						a = append(a, cs)
-					}
-				}
-			}
-			html := []byte(genIndexHTML(a))
+			logInfo(reqCtx(r), "csIndexSend", "uri", uri)
+			a := filterCsListedIn(cheatsheets, uri)
+			categories := buildCategories(a, categoriesCfg)
+			html := []byte(genIndexHTML(a, categories, buildTags(listed)))
 			if r == nil {
 				w.Write(html)
 				return
@@ -143,18 +150,121 @@ func buildContentCheatsheets() []server.Handler {
 		return send
 	}
 	csIndexURLS := func() []string {
-		return []string{"/index.html", "/all.html"}
+		return []string{"/index.html", "/all.html", "/drafts.html"}
 	}
+	csAPIMatches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
+		// match /api/cheatsheet/go.json => go
+		name := strings.TrimPrefix(uri, "/api/cheatsheet/")
+		if len(name) == len(uri) || !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, ".json"))
+		cs := findCheatsheetByName(cheatsheets, name)
+		if cs == nil {
+			return nil
+		}
+		send := func(w http.ResponseWriter, r *http.Request) {
+			cs := reprocessCheatSheet(cs)
+			d, err := genCheatsheetJSON(cs, cheatsheets)
+			if err != nil {
+				serveCsRenderError(w, r, cs, err)
+				return
+			}
+			if r == nil {
+				w.Write(d)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			http.ServeContent(w, r, "foo.json", time.Time{}, bytes.NewReader(d))
+		}
+		return send
+	}
+	csAPIURLS := func() []string {
+		var res []string
+		for _, cs := range cheatsheets {
+			res = append(res, csAPIURL(cs))
+		}
+		return res
+	}
+
 	csIndexDynamic := server.NewDynamicHandler(csIndexMatches, csIndexURLS)
 	csDynamic := server.NewDynamicHandler(csMatches, csURLS)
-	return []server.Handler{csIndexDynamic, csDynamic}
+	csAPIDynamic := server.NewDynamicHandler(csAPIMatches, csAPIURLS)
+	handlers := []server.Handler{csIndexDynamic, csDynamic, csAPIDynamic}
+	handlers = append(handlers, buildContentPrint(cheatsheets)...)
+	handlers = append(handlers, buildContentCategories(listed, categoriesCfg)...)
+	handlers = append(handlers, buildContentTags(listed)...)
+	handlers = append(handlers, buildContentSearch(cheatsheets)...)
+	return append(handlers, buildContentFeeds(cheatsheets)...)
 }
 
-func makeServerDynamic() *server.Server {
-	staticFiles := []string{
+// buildContentSearch returns handlers for static full-text search index
+// and /api/search. The index is built on first use
+func buildContentSearch(cheatsheets []*cheatSheet) []server.Handler {
+	var (
+		mu           sync.Mutex
+		searchIdx    *fullTextIndex
+		searchShards map[string][]byte
+	)
+	getSearchIndex := func() (*fullTextIndex, map[string][]byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		if searchIdx == nil {
+			searchIdx = buildFullTextIndex(cheatsheets)
+			searchShards = searchIdx.shards()
+		}
+		return searchIdx, searchShards
+	}
+
+	shardMatches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(uri, "/search/") {
+			return nil
+		}
+		_, shards := getSearchIndex()
+		d, ok := shards[uri]
+		if !ok {
+			return nil
+		}
+		return server.MakeServeContent(uri, d)
+	}
+	shardURLS := func() []string {
+		_, shards := getSearchIndex()
+		var res []string
+		for uri := range shards {
+			res = append(res, uri)
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	apiMatches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
+		if uri != "/api/search" {
+			return nil
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			idx, _ := getSearchIndex()
+			serveSearch(w, r, idx)
+		}
+	}
+	apiURLS := func() []string {
+		// only in dynamic server, not written out by generateStatic
+		return nil
+	}
+
+	shardsDynamic := server.NewDynamicHandler(shardMatches, shardURLS)
+	apiDynamic := server.NewDynamicHandler(apiMatches, apiURLS)
+	return []server.Handler{shardsDynamic, apiDynamic}
+}
+
+// staticFiles returns pairs of url and path of static files
+func staticFiles() []string {
+	files := []string{
 		"/s/cheatsheet.css",
 		"cheatsheet.css",
 
+		"/s/cheatsheet.print.css",
+		"cheatsheet.print.css",
+
 		"/s/cheatsheet.js",
 		"cheatsheet.js",
 
@@ -164,14 +274,17 @@ func makeServerDynamic() *server.Server {
 		"/ping.txt",
 		"ping.txt",
 	}
-	for i := 0; i < len(staticFiles); i += 2 {
-		name := staticFiles[i+1]
-		staticFiles[i+1] = filepath.Join("www", name)
+	for i := 0; i < len(files); i += 2 {
+		name := files[i+1]
+		files[i+1] = filepath.Join("www", name)
 	}
-	h := server.NewFilesHandler(staticFiles...)
+	return files
+}
+
+func makeServerDynamic(cheatsheets []*cheatSheet) *server.Server {
+	h := server.NewFilesHandler(staticFiles()...)
 	handlers := []server.Handler{h}
-	cheatsheets := buildContentCheatsheets()
-	handlers = append(handlers, cheatsheets...)
+	handlers = append(handlers, buildContentCheatsheets(cheatsheets)...)
 
 	return &server.Server{
 		Handlers:  handlers,
@@ -186,8 +299,31 @@ func runServerDynamic() {
 
 	closeHTTPLog := OpenHTTPLog("cheatsheets")
 	defer closeHTTPLog()
+	closeAppLog := OpenAppLog("cheatsheets")
+	defer closeAppLog()
+
+	liveReload = true
+	genPrint = true
+	notifier := newReloadNotifier()
+	content := &reloadingHandler{}
+	content.setHandlers(makeServerDynamic(readCheatSheets()).Handlers)
+	onChange := func() {
+		defer func() {
+			if p := recover(); p != nil {
+				logerrf(ctx(), "runServerDynamic: reloading failed with %v\n", p)
+			}
+		}()
+		logf(ctx(), "runServerDynamic: files changed, reloading\n")
+		content.setHandlers(makeServerDynamic(readCheatSheets()).Handlers)
+		notifier.notify()
+	}
+	watchForReload(onChange, csDir, csTmplDir)
 
-	srv := makeServerDynamic()
+	srv := &server.Server{
+		Handlers:  []server.Handler{notifier.handler(), content},
+		CleanURLS: true,
+		Port:      httpPort,
+	}
 	httpSrv := makeHTTPServer(srv)
 	logf(ctx(), "Starting server on http://%s'\n", httpSrv.Addr)
 	if isWindows() {
@@ -200,12 +336,23 @@ func runServerDynamic() {
 func runServerProd() {
 	printLoggingStats()
 	panicIf(!dirExists(dirWwwGenerated))
-	h := server.NewDirHandler(dirWwwGenerated, "/", nil)
+	// don't serve build manifest and pre-compressed files, which
+	// are served instead of the original if the browser accepts them
+	acceptFile := func(path string) bool {
+		switch filepath.Ext(path) {
+		case ".br", ".gz":
+			return false
+		}
+		return filepath.Base(path) != buildManifestName
+	}
+	h := server.NewDirHandler(dirWwwGenerated, "/", acceptFile)
 	h.TryServeCompressed = true
-	logf(ctx(), "runServerProd starting, hasSpacesCreds: %v, %d urls\n", hasSpacesCreds(), len(h.URLS()))
+	logf(ctx(), "runServerProd starting, %d urls\n", len(h.URLS()))
 
 	closeHTTPLog := OpenHTTPLog("cheatsheets")
 	defer closeHTTPLog()
+	closeAppLog := OpenAppLog("cheatsheets")
+	defer closeAppLog()
 
 	srv := &server.Server{
 		Handlers:  []server.Handler{h},
@@ -221,24 +368,110 @@ func runServerProd() {
 	logf(ctx(), "runServerProd: httpSrv.ListenAndServe() returned '%s'\n", err)
 }
 
-func generateStatic() {
+// generateStatic writes all files to www_generated
+// unless full is true, only re-generates files whose inputs changed
+// if there are broken cheatsheets, we don't generate anything
+// unless skipBroken is true, in which case we skip them
+func generateStatic(full bool, skipBroken bool) {
 	timeStart := time.Now()
 	defer func() {
 		logf(ctx(), "generateStatic() finished in %s\n", formatDuration(time.Since(timeStart)))
 	}()
-	srv := makeServerDynamic()
-	must(os.RemoveAll(dirWwwGenerated))
+	cheatsheets, errs := validateCheatsheets(readCheatSheets())
+	if len(errs) > 0 {
+		for _, err := range errs {
+			logerrf(ctx(), "  %s\n", err)
+		}
+		if !skipBroken {
+			logerrf(ctx(), "generateStatic: %d broken cheatsheets, fix them or use -skip-broken\n", len(errs))
+			exit(1)
+		}
+		logWarn(ctx(), "generateStatic: skipping broken cheatsheets", "count", len(errs))
+	}
+	srv := makeServerDynamic(cheatsheets)
+	if full {
+		must(os.RemoveAll(dirWwwGenerated))
+	}
+	must(os.MkdirAll(dirWwwGenerated, 0755))
+
+	manifestPath := filepath.Join(dirWwwGenerated, buildManifestName)
+	prev := loadBuildManifest(manifestPath)
+	curr := &buildManifest{
+		Outputs:       map[string]string{},
+		Precompressed: map[string]bool{},
+	}
+	inputs := newBuildInputs(cheatsheets)
 
-	nFiles := 0
+	var added, changed, removed []string
+	// files that need .br and .gz versions
+	var toCompress []string
+	nUnchanged := 0
 	totalSize := int64(0)
-	onWritten := func(path string, d []byte) {
-		fsize := int64(len(d))
-		totalSize += fsize
-		sizeStr := formatSize(fsize)
-		if nFiles%256 == 0 {
-			logf(ctx(), "generateStatic: file %d '%s' of size %s\n", nFiles+1, path, sizeStr)
+	for _, h := range srv.Handlers {
+		for _, uri := range h.URLS() {
+			hash := inputs.hash(uri)
+			curr.Outputs[uri] = hash
+			path := urlToGeneratedPath(dirWwwGenerated, uri)
+			prevHash, existed := prev.Outputs[uri]
+			if existed && prevHash == hash && fileExists(path) {
+				nUnchanged++
+				// manifest from before we remembered pre-compressed
+				// files doesn't have it
+				if prev.Precompressed[uri] || hasPrecompressed(path) {
+					curr.Precompressed[uri] = true
+				} else if st, err := os.Stat(path); err == nil && canPrecompress(path, st.Size()) {
+					// www_generated might be from before we pre-compressed
+					toCompress = append(toCompress, path)
+					curr.Precompressed[uri] = true
+				}
+				continue
+			}
+			serve := h.Get(uri)
+			panicIf(serve == nil, "must have a handler for '%s'", uri)
+			w := &memResponseWriter{}
+			serve(w, nil)
+			must(os.MkdirAll(filepath.Dir(path), 0755))
+			must(os.WriteFile(path, w.d, 0644))
+			totalSize += int64(len(w.d))
+			if canPrecompress(path, int64(len(w.d))) {
+				toCompress = append(toCompress, path)
+				curr.Precompressed[uri] = true
+			} else {
+				removePrecompressed(path)
+			}
+			if existed {
+				changed = append(changed, uri)
+			} else {
+				added = append(added, uri)
+			}
+		}
+	}
+	for uri := range prev.Outputs {
+		if _, ok := curr.Outputs[uri]; ok {
+			continue
+		}
+		path := urlToGeneratedPath(dirWwwGenerated, uri)
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			logerrf(ctx(), "generateStatic: os.Remove('%s') failed with '%s'\n", path, err)
+		}
+		removePrecompressed(path)
+		removed = append(removed, uri)
+	}
+	comprStats := precompressFiles(toCompress)
+	curr.save(manifestPath)
+
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	logChanges := func(what string, a []string) {
+		for _, uri := range a {
+			logvf(ctx(), "  %s %s\n", what, uri)
 		}
-		nFiles++
 	}
-	server.WriteServerFilesToDir(dirWwwGenerated, srv.Handlers, onWritten)
+	logChanges("added:  ", added)
+	logChanges("changed:", changed)
+	logChanges("removed:", removed)
+	comprStats.log("generateStatic: pre-compressed")
+	logf(ctx(), "generateStatic: %d added, %d changed, %d removed, %d unchanged, wrote %s\n", len(added), len(changed), len(removed), nUnchanged, formatSize(totalSize))
 }
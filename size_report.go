@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -size-report measures size of generated pages, raw and compressed,
+// and optionally compares it with a previous report to catch regressions
+
+// brotli levels we measure. brotliLevel is what generateStatic() uses
+var sizeReportBrotliLevels = []int{1, 5, brotliLevel, 11}
+
+const (
+	// how many of the largest pages and search indexes we show
+	sizeReportTopN = 10
+
+	// growth of a page that we report as a regression, in percent
+	// of previous size and in bytes
+	sizeRegressionPerc  = 5.0
+	sizeRegressionBytes = 512
+
+	// gzipCompress() uses gzip.BestCompression
+	gzipAlgo = "gzip-9"
+)
+
+type comprSize struct {
+	// "gzip-9", "br-5" etc.
+	Algo string `json:"algo"`
+	Size int64  `json:"size"`
+	// how long it took to compress, in milliseconds
+	DurationMs float64 `json:"duration_ms"`
+}
+
+type pageSize struct {
+	URL        string       `json:"url"`
+	Raw        int64        `json:"raw"`
+	Compressed []*comprSize `json:"compressed"`
+	// size of search index inlined in cheatsheet pages
+	InlineSearchIndex int64 `json:"inline_search_index,omitempty"`
+}
+
+type sizeReport struct {
+	Generated time.Time   `json:"generated"`
+	Pages     []*pageSize `json:"pages"`
+}
+
+func brotliAlgo(level int) string {
+	return fmt.Sprintf("br-%d", level)
+}
+
+// deployedAlgo is compression of files we serve, see precompress.go
+func deployedAlgo() string {
+	return brotliAlgo(brotliLevel)
+}
+
+func (p *pageSize) comprSize(algo string) int64 {
+	for _, c := range p.Compressed {
+		if c.Algo == algo {
+			return c.Size
+		}
+	}
+	return 0
+}
+
+// inlineSearchIndexSize returns size of searchIndexJSON
+// in cheatsheet.tmpl.html
+func inlineSearchIndexSize(d []byte) int64 {
+	s := string(d)
+	start := "let searchIndexJSON = `"
+	i := strings.Index(s, start)
+	if i < 0 {
+		return 0
+	}
+	s = s[i+len(start):]
+	end := strings.Index(s, "`;")
+	if end < 0 {
+		return 0
+	}
+	return int64(end)
+}
+
+func measurePage(uri string, d []byte) *pageSize {
+	res := &pageSize{
+		URL:               uri,
+		Raw:               int64(len(d)),
+		InlineSearchIndex: inlineSearchIndexSize(d),
+	}
+	measure := func(algo string, compress func([]byte) []byte) {
+		timeStart := time.Now()
+		n := len(compress(d))
+		dur := time.Since(timeStart)
+		res.Compressed = append(res.Compressed, &comprSize{
+			Algo:       algo,
+			Size:       int64(n),
+			DurationMs: float64(dur) / float64(time.Millisecond),
+		})
+	}
+	measure(gzipAlgo, gzipCompress)
+	for _, level := range sizeReportBrotliLevels {
+		level := level
+		measure(brotliAlgo(level), func(d []byte) []byte {
+			return brotliCompressLevel(d, level)
+		})
+	}
+	return res
+}
+
+// buildSizeReport generates all pages in memory, like generateStatic()
+// and measures them
+func buildSizeReport() *sizeReport {
+	cheatsheets, errs := validateCheatsheets(readCheatSheets())
+	for _, err := range errs {
+		logerrf(ctx(), "  skipping: %s\n", err)
+	}
+	srv := makeServerDynamic(cheatsheets)
+	// don't drown the report in logging from generating pages
+	logQuiet = true
+	defer func() {
+		logQuiet = false
+	}()
+	res := &sizeReport{
+		Generated: time.Now().UTC(),
+	}
+	for _, h := range srv.Handlers {
+		for _, uri := range h.URLS() {
+			serve := h.Get(uri)
+			panicIf(serve == nil, "must have a handler for '%s'", uri)
+			w := &memResponseWriter{}
+			serve(w, nil)
+			res.Pages = append(res.Pages, measurePage(uri, w.d))
+		}
+	}
+	sort.Slice(res.Pages, func(i, j int) bool {
+		return res.Pages[i].URL < res.Pages[j].URL
+	})
+	return res
+}
+
+func loadSizeReport(path string) (*sizeReport, error) {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var res sizeReport
+	err = json.Unmarshal(d, &res)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &res, nil
+}
+
+func (r *sizeReport) save(path string) {
+	d, err := json.MarshalIndent(r, "", "  ")
+	must(err)
+	must(os.WriteFile(path, d, 0644))
+}
+
+func (r *sizeReport) log() {
+	var total int64
+	totals := map[string]int64{}
+	durs := map[string]float64{}
+	var algos []string
+	for _, p := range r.Pages {
+		total += p.Raw
+		for _, c := range p.Compressed {
+			if _, ok := totals[c.Algo]; !ok {
+				algos = append(algos, c.Algo)
+			}
+			totals[c.Algo] += c.Size
+			durs[c.Algo] += c.DurationMs
+		}
+	}
+	logf(ctx(), "%d pages\n", len(r.Pages))
+	logf(ctx(), "%-8s %10d %10s\n", "raw", total, formatSize(total))
+	for _, algo := range algos {
+		dur := time.Duration(durs[algo] * float64(time.Millisecond))
+		logf(ctx(), "%-8s %10d %10s %6.2f%% in %s\n", algo, totals[algo], formatSize(totals[algo]), perc(total, totals[algo]), formatDuration(dur))
+	}
+
+	a := append([]*pageSize(nil), r.Pages...)
+	sort.SliceStable(a, func(i, j int) bool {
+		return a[i].Raw > a[j].Raw
+	})
+	logf(ctx(), "\nlargest pages (raw / %s):\n", deployedAlgo())
+	for i := 0; i < len(a) && i < sizeReportTopN; i++ {
+		p := a[i]
+		logf(ctx(), "  %10s %10s  %s\n", formatSize(p.Raw), formatSize(p.comprSize(deployedAlgo())), p.URL)
+	}
+
+	sort.SliceStable(a, func(i, j int) bool {
+		return a[i].InlineSearchIndex > a[j].InlineSearchIndex
+	})
+	logf(ctx(), "\nlargest inline search indexes (index / page):\n")
+	for i := 0; i < len(a) && i < sizeReportTopN && a[i].InlineSearchIndex > 0; i++ {
+		p := a[i]
+		logf(ctx(), "  %10s %5.1f%%  %s\n", formatSize(p.InlineSearchIndex), perc(p.Raw, p.InlineSearchIndex), p.URL)
+	}
+}
+
+func isSizeRegression(prev, curr int64) bool {
+	diff := curr - prev
+	return diff > sizeRegressionBytes && perc(prev, diff) > sizeRegressionPerc
+}
+
+// diff logs changes in size compared to prev report and returns
+// number of size regressions
+func (r *sizeReport) diff(prev *sizeReport) int {
+	prevByURL := map[string]*pageSize{}
+	for _, p := range prev.Pages {
+		prevByURL[p.URL] = p
+	}
+	algo := deployedAlgo()
+	var totalPrev, totalCurr int64
+	nRegressions := 0
+	logf(ctx(), "\nchanges since %s (raw / %s):\n", prev.Generated.Format(time.RFC3339), algo)
+	for _, p := range r.Pages {
+		pp := prevByURL[p.URL]
+		delete(prevByURL, p.URL)
+		totalCurr += p.Raw
+		if pp == nil {
+			logf(ctx(), "  added:      %10s %10s  %s\n", formatSize(p.Raw), formatSize(p.comprSize(algo)), p.URL)
+			continue
+		}
+		totalPrev += pp.Raw
+		isRegression := isSizeRegression(pp.Raw, p.Raw) || isSizeRegression(pp.comprSize(algo), p.comprSize(algo))
+		if !isRegression {
+			continue
+		}
+		nRegressions++
+		logf(ctx(), "  regression: %10s => %s, %s => %s  %s\n", formatSize(pp.Raw), formatSize(p.Raw), formatSize(pp.comprSize(algo)), formatSize(p.comprSize(algo)), p.URL)
+	}
+	var removed []string
+	for uri, pp := range prevByURL {
+		totalPrev += pp.Raw
+		removed = append(removed, uri)
+	}
+	sort.Strings(removed)
+	for _, uri := range removed {
+		logf(ctx(), "  removed:    %s\n", uri)
+	}
+	logf(ctx(), "total: %s => %s\n", formatSize(totalPrev), formatSize(totalCurr))
+	logf(ctx(), "%d size regressions (more than %.0f%% and %d bytes)\n", nRegressions, sizeRegressionPerc, sizeRegressionBytes)
+	return nRegressions
+}
+
+// runSizeReport measures generated pages, saves report as JSON to
+// outPath and compares with report in prevPath, if given
+// Returns exit code: 1 if there are size regressions
+func runSizeReport(outPath string, prevPath string) int {
+	var prev *sizeReport
+	if prevPath != "" {
+		// load it first, it's likely the same file as outPath
+		var err error
+		prev, err = loadSizeReport(prevPath)
+		if err != nil {
+			logerrf(ctx(), "runSizeReport: %s\n", err)
+			return 1
+		}
+	}
+	r := buildSizeReport()
+	r.log()
+	if outPath != "" {
+		r.save(outPath)
+		logf(ctx(), "\nwrote size report to '%s'\n", outPath)
+	}
+	if prev != nil && r.diff(prev) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/kjk/common/siser"
+)
+
+// -stats reads http logs written by httplogger (logs/httplog-${day}_${hour}.txt
+// and .txt.br) and reports most popular cheatsheets, referrers, 404s,
+// status codes and latency
+
+const statsDefaultDays = 7
+
+type statsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type statsLatency struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+type httpStats struct {
+	From        string        `json:"from"`
+	To          string        `json:"to"`
+	Files       int           `json:"files"`
+	Requests    int           `json:"requests"`
+	Cheatsheets []*statsCount `json:"top_cheatsheets"`
+	Referrers   []*statsCount `json:"top_referrers"`
+	NotFound    []*statsCount `json:"top_404s"`
+	StatusCodes []*statsCount `json:"status_codes"`
+	Latency     *statsLatency `json:"latency"`
+}
+
+// httpLogDay returns day and hour of httplog-2021-10-06_01.txt[.br]
+func httpLogDay(path string) (time.Time, bool) {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, "httplog-") {
+		return time.Time{}, false
+	}
+	name = strings.TrimPrefix(name, "httplog-")
+	name = strings.TrimSuffix(name, ".br")
+	name = strings.TrimSuffix(name, ".txt")
+	// httplogger names files using local time
+	t, err := time.ParseInLocation("2006-01-02_15", name, time.Local)
+	return t, err == nil
+}
+
+// findHTTPLogs returns http logs in dir for days from .. to (inclusive)
+func findHTTPLogs(dir string, from, to time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		t, ok := httpLogDay(path)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if t.Before(from) || !t.Before(to.AddDate(0, 0, 1)) {
+			continue
+		}
+		res = append(res, path)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// parseReq parses "GET /cheatsheet/go.html?x=y 200" into url path and status code
+func parseReq(s string) (string, int, bool) {
+	parts := strings.Split(s, " ")
+	if len(parts) != 3 {
+		return "", 0, false
+	}
+	code, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return "", 0, false
+	}
+	uri := parts[1]
+	if idx := strings.IndexByte(uri, '?'); idx >= 0 {
+		uri = uri[:idx]
+	}
+	return uri, code, true
+}
+
+// isExternalReferrer is like in logHTTPReq(): we don't care about
+// navigation within the site
+func isExternalReferrer(ref string, host string) bool {
+	return ref != "" && (host == "" || !strings.Contains(ref, host))
+}
+
+type statsCollector struct {
+	from, to    time.Time
+	requests    int
+	cheatsheets map[string]int
+	referrers   map[string]int
+	notFound    map[string]int
+	statusCodes map[string]int
+	// in milliseconds
+	durations []float64
+}
+
+func (c *statsCollector) addRecord(rec *siser.Record) {
+	if !rec.Timestamp.IsZero() {
+		if rec.Timestamp.Before(c.from) || !rec.Timestamp.Before(c.to.AddDate(0, 0, 1)) {
+			return
+		}
+	}
+	req, _ := rec.Get("req")
+	uri, code, ok := parseReq(req)
+	if !ok {
+		return
+	}
+	if code == 0 {
+		// http server writes 200 when handler didn't set the status
+		code = 200
+	}
+	c.requests++
+	c.statusCodes[strconv.Itoa(code)]++
+	if code == 404 {
+		c.notFound[uri]++
+	}
+	if strings.HasPrefix(uri, "/cheatsheet/") && code < 400 {
+		// count print version as the cheatsheet
+		if strings.HasSuffix(uri, ".print.html") {
+			uri = strings.TrimSuffix(uri, ".print.html") + ".html"
+		}
+		c.cheatsheets[uri]++
+	}
+	host, _ := rec.Get("host")
+	if ref, _ := rec.Get("Referer"); isExternalReferrer(ref, host) {
+		c.referrers[ref]++
+	}
+	if s, ok := rec.Get("durmicro"); ok {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			c.durations = append(c.durations, float64(n)/1000)
+		}
+	}
+}
+
+func (c *statsCollector) addFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".br") {
+		r = brotli.NewReader(f)
+	}
+	sr := siser.NewReader(bufio.NewReader(r))
+	for sr.ReadNextRecord() {
+		c.addRecord(sr.Record)
+	}
+	if err = sr.Err(); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// topCounts returns n entries with highest count, sorted by count
+// n < 0 means all
+func topCounts(m map[string]int, n int) []*statsCount {
+	var res []*statsCount
+	for name, count := range m {
+		res = append(res, &statsCount{Name: name, Count: count})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Name < res[j].Name
+	})
+	if n >= 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// percentile returns p-th percentile of sorted a
+func percentile(a []float64, p float64) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+	idx := int(float64(len(a)-1) * p / 100)
+	return a[idx]
+}
+
+func (c *statsCollector) stats(nFiles int, topN int) *httpStats {
+	sort.Float64s(c.durations)
+	res := &httpStats{
+		From:        c.from.Format("2006-01-02"),
+		To:          c.to.Format("2006-01-02"),
+		Files:       nFiles,
+		Requests:    c.requests,
+		Cheatsheets: topCounts(c.cheatsheets, topN),
+		Referrers:   topCounts(c.referrers, topN),
+		NotFound:    topCounts(c.notFound, topN),
+		StatusCodes: topCounts(c.statusCodes, -1),
+		Latency: &statsLatency{
+			P50: percentile(c.durations, 50),
+			P90: percentile(c.durations, 90),
+			P95: percentile(c.durations, 95),
+			P99: percentile(c.durations, 99),
+			Max: percentile(c.durations, 100),
+		},
+	}
+	return res
+}
+
+func (s *httpStats) print() {
+	fmt.Printf("%d requests from %s to %s in %d files\n", s.Requests, s.From, s.To, s.Files)
+	printCounts := func(title string, a []*statsCount) {
+		fmt.Printf("\n%s:\n", title)
+		for _, c := range a {
+			fmt.Printf("  %7d %5.1f%%  %s\n", c.Count, perc(int64(s.Requests), int64(c.Count)), c.Name)
+		}
+	}
+	printCounts("status codes", s.StatusCodes)
+	printCounts("top cheatsheets", s.Cheatsheets)
+	printCounts("top referrers", s.Referrers)
+	printCounts("top 404s", s.NotFound)
+	l := s.Latency
+	fmt.Printf("\nlatency: p50 %.2f ms, p90 %.2f ms, p95 %.2f ms, p99 %.2f ms, max %.2f ms\n", l.P50, l.P90, l.P95, l.P99, l.Max)
+}
+
+// parseStatsDates parses -stats-from and -stats-to. Default is last
+// statsDefaultDays days
+func parseStatsDates(fromStr, toStr string) (time.Time, time.Time, error) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if toStr != "" {
+		t, err := time.ParseInLocation("2006-01-02", toStr, time.Local)
+		if err != nil {
+			return to, to, fmt.Errorf("invalid date '%s', must be YYYY-MM-DD", toStr)
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -(statsDefaultDays - 1))
+	if fromStr != "" {
+		t, err := time.ParseInLocation("2006-01-02", fromStr, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid date '%s', must be YYYY-MM-DD", fromStr)
+		}
+		from = t
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("-stats-from %s is after -stats-to %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+	return from, to, nil
+}
+
+// runStats reports stats from http logs in dir, days from .. to (YYYY-MM-DD)
+// format is "text" or "json". Returns exit code
+func runStats(dir string, fromStr, toStr string, format string, topN int) int {
+	if format != "text" && format != "json" {
+		logerrf(ctx(), "runStats: invalid format '%s', must be text or json\n", format)
+		return 1
+	}
+	from, to, err := parseStatsDates(fromStr, toStr)
+	if err != nil {
+		logerrf(ctx(), "runStats: %s\n", err)
+		return 1
+	}
+	paths, err := findHTTPLogs(dir, from, to)
+	if err != nil {
+		logerrf(ctx(), "runStats: %s\n", err)
+		return 1
+	}
+	c := &statsCollector{
+		from:        from,
+		to:          to,
+		cheatsheets: map[string]int{},
+		referrers:   map[string]int{},
+		notFound:    map[string]int{},
+		statusCodes: map[string]int{},
+	}
+	for _, path := range paths {
+		if err := c.addFile(path); err != nil {
+			// a log file that is still being written can end
+			// with a partial record
+			logerrf(ctx(), "runStats: %s\n", err)
+		}
+	}
+	stats := c.stats(len(paths), topN)
+	if format == "json" {
+		d, err := json.MarshalIndent(stats, "", "  ")
+		must(err)
+		fmt.Printf("%s\n", d)
+		return 0
+	}
+	stats.print()
+	return 0
+}
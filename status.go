@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// status of a cheatsheet, from 'status' in front matter. If not
+// given, it's based on the directory the cheatsheet is in
+const (
+	// not ready, only in /drafts.html
+	csStatusDraft = "draft"
+	// imported and needs review, in /all.html and /drafts.html
+	csStatusReview = "review"
+	// in /index.html and /all.html
+	csStatusPublished = "published"
+	// not listed anywhere, but the page still exists
+	csStatusObsolete = "obsolete"
+)
+
+var csStatuses = []string{csStatusDraft, csStatusReview, csStatusPublished, csStatusObsolete}
+
+// maps directory in cheatsheets/ to status of cheatsheets in it
+var csStatusByDir = map[string]string{
+	"good":              csStatusPublished,
+	"toimprove":         csStatusReview,
+	"devhints-toreview": csStatusReview,
+	"boad":              csStatusDraft,
+	"obsolete":          csStatusObsolete,
+}
+
+func isValidCsStatus(status string) bool {
+	for _, s := range csStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// csStatusFromPath returns status based on directory of a cheatsheet
+// e.g. cheatsheets/good/go.md is published
+func csStatusFromPath(path string) string {
+	rel, err := filepath.Rel(csDir, path)
+	if err == nil {
+		dir := strings.Split(filepath.ToSlash(rel), "/")[0]
+		if status, ok := csStatusByDir[dir]; ok {
+			return status
+		}
+	}
+	return csStatusDraft
+}
+
+// csListedIn returns true if cheatsheet should be listed on index page
+// with a given url: /index.html, /all.html or /drafts.html
+func csListedIn(cs *cheatSheet, uri string) bool {
+	switch uri {
+	case "/index.html":
+		return cs.status == csStatusPublished && !csHasTag(cs, csTagArchived)
+	case "/all.html":
+		return cs.status == csStatusPublished || cs.status == csStatusReview
+	case "/drafts.html":
+		return cs.status == csStatusDraft || cs.status == csStatusReview
+	}
+	return false
+}
+
+// filterCsListedIn returns cheatsheets listed on index page with a given url
+func filterCsListedIn(cheatsheets []*cheatSheet, uri string) []*cheatSheet {
+	var res []*cheatSheet
+	for _, cs := range cheatsheets {
+		if csListedIn(cs, uri) {
+			res = append(res, cs)
+		}
+	}
+	return res
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aymerick/raymond"
+	"github.com/kjk/common/server"
+)
+
+// well-known tags from front matter that change how a cheatsheet is shown
+const (
+	// not shown in /index.html
+	csTagArchived = "Archived"
+	// shown with a "work in progress" banner
+	csTagWIP = "WIP"
+)
+
+type csTag struct {
+	Name        string
+	URL         string
+	Cheatsheets []*cheatSheet
+	Count       int
+}
+
+// normalizeTags removes empty and duplicate tags
+func normalizeTags(tags []string) []string {
+	var res []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		slug := slugify(tag)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		res = append(res, tag)
+	}
+	return res
+}
+
+// slugify returns tag or category name suitable for url:
+// "Featured" => "featured", "Java & JVM" => "java-jvm"
+func slugify(s string) string {
+	var sb strings.Builder
+	prevDash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			sb.WriteRune(r)
+			prevDash = false
+		case r == ' ' || r == '-' || r == '_':
+			if !prevDash {
+				sb.WriteRune('-')
+			}
+			prevDash = true
+		}
+	}
+	return strings.Trim(sb.String(), "-")
+}
+
+func tagURL(tag string) string {
+	return "/tag/" + slugify(tag) + ".html"
+}
+
+func csHasTag(cs *cheatSheet, tag string) bool {
+	slug := slugify(tag)
+	for _, t := range cs.meta.Tags {
+		if slugify(t) == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// buildTags returns all tags used by cheatsheets, sorted by name
+func buildTags(cheatsheets []*cheatSheet) []*csTag {
+	bySlug := map[string]*csTag{}
+	for _, cs := range cheatsheets {
+		for _, t := range cs.meta.Tags {
+			slug := slugify(t)
+			tag := bySlug[slug]
+			if tag == nil {
+				tag = &csTag{
+					Name: t,
+					URL:  tagURL(t),
+				}
+				bySlug[slug] = tag
+			}
+			tag.Cheatsheets = append(tag.Cheatsheets, cs)
+			tag.Count++
+		}
+	}
+	var res []*csTag
+	for _, tag := range bySlug {
+		sortCheatsheets(tag.Cheatsheets)
+		res = append(res, tag)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return strings.ToLower(res[i].Name) < strings.ToLower(res[j].Name)
+	})
+	return res
+}
+
+// genListHTML generates a page with a list of cheatsheets, like
+// cheatsheets with a given tag or in a category
+func genListHTML(title string, description string, cheatsheets []*cheatSheet) []byte {
+	tpl := string(readFileMust(filepath.Join(csTmplDir, "list.tmpl.html")))
+	ctx := map[string]interface{}{
+		"title":         title,
+		"description":   description,
+		"cheatsheets":   cheatsheets,
+		"liveReload":    liveReload,
+		"liveReloadURL": liveReloadURL,
+	}
+	return []byte(raymond.MustRender(tpl, ctx))
+}
+
+// buildContentTags returns handler for /tag/${tag}.html pages
+func buildContentTags(cheatsheets []*cheatSheet) []server.Handler {
+	tags := buildTags(cheatsheets)
+	matches := func(uri string) func(w http.ResponseWriter, r *http.Request) {
+		for _, tag := range tags {
+			if uri == tag.URL {
+				return func(w http.ResponseWriter, r *http.Request) {
+					html := genListHTML("Tagged "+tag.Name, "", tag.Cheatsheets)
+					server.MakeServeContent(uri, html)(w, r)
+				}
+			}
+		}
+		return nil
+	}
+	urls := func() []string {
+		var res []string
+		for _, tag := range tags {
+			res = append(res, tag.URL)
+		}
+		return res
+	}
+	return []server.Handler{server.NewDynamicHandler(matches, urls)}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// terminal commands for reading cheatsheets offline:
+// cheatsheets show <name> [section]
+// cheatsheets search <term>
+
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiUnderline = "\x1b[4m"
+	ansiDim       = "\x1b[2m"
+	ansiCyan      = "\x1b[36m"
+	ansiYellow    = "\x1b[33m"
+	ansiGreen     = "\x1b[32m"
+
+	termMaxSearchResults = 20
+)
+
+// termRenderer renders markdown ast of a cheatsheet as ANSI-colored text
+type termRenderer struct {
+	w         io.Writer
+	useColors bool
+	formatter chroma.Formatter
+	style     *chroma.Style
+}
+
+func newTermRenderer(w io.Writer, useColors bool) *termRenderer {
+	r := &termRenderer{
+		w:         w,
+		useColors: useColors,
+		formatter: formatters.NoOp,
+		style:     styles.Fallback,
+	}
+	if useColors {
+		r.formatter = formatters.TTY256
+		// monokailight used for html is hard to read on dark terminals
+		if s := styles.Get("monokai"); s != nil {
+			r.style = s
+		}
+	}
+	return r
+}
+
+// isTerminal returns true if f is a terminal (and not e.g. a pipe)
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return st.Mode()&os.ModeCharDevice != 0
+}
+
+func (r *termRenderer) color(s string, codes ...string) string {
+	if !r.useColors || s == "" {
+		return s
+	}
+	return strings.Join(codes, "") + s + ansiReset
+}
+
+// inline renders inline content of a block node
+func (r *termRenderer) inline(node ast.Node) string {
+	var sb strings.Builder
+	for _, c := range node.GetChildren() {
+		switch v := c.(type) {
+		case *ast.Text:
+			sb.Write(v.Literal)
+		case *ast.Code:
+			sb.WriteString(r.color(string(v.Literal), ansiYellow))
+		case *ast.Strong, *ast.Emph:
+			sb.WriteString(r.color(r.inline(v), ansiBold))
+		case *ast.Link:
+			s := r.inline(v)
+			dest := string(v.Destination)
+			if dest != "" && dest != s && !strings.HasPrefix(dest, "#") {
+				s += r.color(" ("+dest+")", ansiDim)
+			}
+			sb.WriteString(r.color(s, ansiUnderline))
+		case *ast.Hardbreak, *ast.Softbreak:
+			sb.WriteString("\n")
+		case *ast.HTMLSpan:
+			// skip raw html tags
+		default:
+			if l := c.AsLeaf(); l != nil {
+				sb.Write(l.Literal)
+			} else {
+				sb.WriteString(r.inline(c))
+			}
+		}
+	}
+	return sb.String()
+}
+
+func (r *termRenderer) code(source string, lang string) {
+	l := lexers.Get(lang)
+	if l == nil {
+		l = lexers.Analyse(source)
+	}
+	if l == nil {
+		l = lexers.Fallback
+	}
+	l = chroma.Coalesce(l)
+	it, err := l.Tokenise(nil, source)
+	if err == nil {
+		var sb strings.Builder
+		err = r.formatter.Format(&sb, r.style, it)
+		source = sb.String()
+	}
+	if err != nil {
+		logerrf(ctx(), "termRenderer.code: %s\n", err)
+	}
+	for _, line := range strings.Split(strings.TrimRight(source, "\n"), "\n") {
+		fmt.Fprintf(r.w, "    %s\n", line)
+	}
+	if r.useColors {
+		io.WriteString(r.w, ansiReset)
+	}
+	fmt.Fprintln(r.w)
+}
+
+func (r *termRenderer) table(table *ast.Table) {
+	var rows [][]string
+	nHeader := 0
+	ast.WalkFunc(table, func(node ast.Node, entering bool) ast.WalkStatus {
+		row, ok := node.(*ast.TableRow)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		var cells []string
+		for _, c := range row.GetChildren() {
+			cells = append(cells, strings.TrimSpace(astText(c)))
+		}
+		rows = append(rows, cells)
+		if _, isHeader := row.GetParent().(*ast.TableHeader); isHeader {
+			nHeader++
+		}
+		return ast.SkipChildren
+	})
+
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for rowNo, row := range rows {
+		var sb strings.Builder
+		sb.WriteString("  ")
+		for i, cell := range row {
+			pad := widths[i] - utf8.RuneCountInString(cell)
+			if rowNo < nHeader {
+				cell = r.color(cell, ansiBold)
+			}
+			sb.WriteString(cell + strings.Repeat(" ", pad+2))
+		}
+		fmt.Fprintln(r.w, strings.TrimRight(sb.String(), " "))
+	}
+	fmt.Fprintln(r.w)
+}
+
+func (r *termRenderer) list(list *ast.List, indent string) {
+	// Start is 0 for lists starting with 1 and, without
+	// parser.OrderedListStart extension, for all lists
+	start := list.Start
+	if start == 0 {
+		start = 1
+	}
+	for i, item := range list.GetChildren() {
+		bullet := "•"
+		if list.ListFlags&ast.ListTypeOrdered != 0 {
+			bullet = fmt.Sprintf("%d.", start+i)
+		}
+		first := true
+		for _, c := range item.GetChildren() {
+			if sub, ok := c.(*ast.List); ok {
+				r.list(sub, indent+"  ")
+				continue
+			}
+			s := r.inline(c)
+			if first {
+				fmt.Fprintf(r.w, "%s%s %s\n", indent, bullet, s)
+				first = false
+			} else {
+				fmt.Fprintf(r.w, "%s  %s\n", indent, s)
+			}
+		}
+	}
+	if indent == "" {
+		fmt.Fprintln(r.w)
+	}
+}
+
+func (r *termRenderer) block(node ast.Node) {
+	switch v := node.(type) {
+	case *ast.Heading:
+		s := strings.Repeat("#", v.Level) + " " + r.inline(v)
+		fmt.Fprintf(r.w, "%s\n\n", r.color(s, ansiBold, ansiCyan))
+	case *ast.Paragraph:
+		fmt.Fprintf(r.w, "%s\n\n", r.inline(v))
+	case *ast.CodeBlock:
+		r.code(string(v.Literal), string(v.Info))
+	case *ast.Table:
+		r.table(v)
+	case *ast.List:
+		r.list(v, "")
+	case *ast.BlockQuote:
+		for _, c := range v.GetChildren() {
+			fmt.Fprintf(r.w, "  │ %s\n", r.inline(c))
+		}
+		fmt.Fprintln(r.w)
+	case *ast.HorizontalRule:
+		fmt.Fprintf(r.w, "%s\n\n", r.color(strings.Repeat("─", 40), ansiDim))
+	case *ast.HTMLBlock:
+		// skip raw html
+	default:
+		if s := strings.TrimSpace(astText(v)); s != "" {
+			fmt.Fprintf(r.w, "%s\n\n", s)
+		}
+	}
+}
+
+// fuzzyScore returns how well pattern matches s, higher is better.
+// Characters of pattern must appear in s in order. Consecutive
+// characters and matches at the beginning of words score higher
+func fuzzyScore(pattern, s string) (int, bool) {
+	pattern = strings.ToLower(pattern)
+	s = strings.ToLower(s)
+	if pattern == "" {
+		return 0, false
+	}
+	if s == pattern {
+		return 1000, true
+	}
+	if strings.HasPrefix(s, pattern) {
+		return 500 - len(s), true
+	}
+	if strings.Contains(s, pattern) {
+		return 300 - len(s), true
+	}
+	score := 0
+	si := 0
+	prevMatched := false
+	for _, pc := range pattern {
+		found := false
+		for si < len(s) {
+			c := rune(s[si])
+			isWordStart := si == 0 || strings.ContainsRune("-_ .", rune(s[si-1]))
+			si++
+			if c == pc {
+				score += 1
+				if prevMatched {
+					score += 5
+				}
+				if isWordStart {
+					score += 10
+				}
+				prevMatched = true
+				found = true
+				break
+			}
+			prevMatched = false
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return score - len(s), true
+}
+
+type fuzzyMatch struct {
+	s     string
+	score int
+}
+
+// fuzzyFind returns candidates matching pattern, best matches first
+func fuzzyFind(pattern string, candidates []string) []fuzzyMatch {
+	var res []fuzzyMatch
+	for _, s := range candidates {
+		if score, ok := fuzzyScore(pattern, s); ok {
+			res = append(res, fuzzyMatch{s, score})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].score > res[j].score
+	})
+	return res
+}
+
+func termFindCheatsheet(cheatsheets []*cheatSheet, name string) (*cheatSheet, []string) {
+	var names []string
+	for _, cs := range cheatsheets {
+		names = append(names, cs.fileNameBase)
+	}
+	matches := fuzzyFind(name, names)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	var others []string
+	for _, m := range matches[1:] {
+		if len(others) == 5 {
+			break
+		}
+		others = append(others, m.s)
+	}
+	return findCheatsheetByName(cheatsheets, matches[0].s), others
+}
+
+// termFindSection returns index of top-level heading in doc best matching
+// section by heading id or heading text
+func termFindSection(doc ast.Node, section string) int {
+	bestIdx, bestScore := -1, 0
+	for i, n := range doc.GetChildren() {
+		h, ok := n.(*ast.Heading)
+		if !ok {
+			continue
+		}
+		for _, s := range []string{h.HeadingID, astText(h)} {
+			score, ok := fuzzyScore(section, s)
+			if ok && (bestIdx == -1 || score > bestScore) {
+				bestIdx, bestScore = i, score
+			}
+		}
+	}
+	return bestIdx
+}
+
+// termShow prints a cheatsheet or, if section is given, a section of it
+// with all its sub-sections
+func termShow(name string, section string) int {
+	logQuiet = true
+	cheatsheets := readCheatSheets()
+	cs, others := termFindCheatsheet(cheatsheets, name)
+	if cs == nil {
+		fmt.Fprintf(os.Stderr, "no cheatsheet matching '%s'\n", name)
+		return 1
+	}
+	if cs.err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", cs.err)
+		return 1
+	}
+	doc := csParseMarkdown(cs)
+	nodes := doc.GetChildren()
+
+	w := os.Stdout
+	r := newTermRenderer(w, isTerminal(w))
+	if section != "" {
+		idx := termFindSection(doc, section)
+		if idx == -1 {
+			fmt.Fprintf(os.Stderr, "no section matching '%s' in '%s'\n", section, cs.fileNameBase)
+			return 1
+		}
+		h := nodes[idx].(*ast.Heading)
+		end := idx + 1
+		for end < len(nodes) {
+			if next, ok := nodes[end].(*ast.Heading); ok && next.Level <= h.Level {
+				break
+			}
+			end++
+		}
+		nodes = nodes[idx:end]
+	}
+	fmt.Fprintf(w, "%s\n\n", r.color(cs.Title, ansiBold, ansiGreen))
+	for _, n := range nodes {
+		r.block(n)
+	}
+	if len(others) > 0 && cs.fileNameBase != strings.ToLower(name) {
+		fmt.Fprintf(os.Stderr, "%s\n", r.color("other matches: "+strings.Join(others, ", "), ansiDim))
+	}
+	return 0
+}
+
+// termSearch prints results of full-text search
+func termSearch(term string) int {
+	logQuiet = true
+	cheatsheets := readCheatSheets()
+	idx := buildFullTextIndex(cheatsheets)
+	results := idx.search(term, termMaxSearchResults)
+	if len(results) == 0 {
+		fmt.Fprintf(os.Stderr, "no results for '%s'\n", term)
+		return 1
+	}
+	w := os.Stdout
+	r := newTermRenderer(w, isTerminal(w))
+	for _, res := range results {
+		what := res.Name
+		if res.HeadingID != "" {
+			what += " " + res.HeadingID
+		}
+		desc := res.Title
+		if res.Heading != "" && res.Heading != res.Title {
+			desc += " › " + res.Heading
+		}
+		fmt.Fprintf(w, "%s  %s\n", r.color(what, ansiBold, ansiCyan), desc)
+	}
+	return 0
+}
+
+// runTermCommand runs show / search command given as args
+// returns process exit code
+func runTermCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "usage:\n  cheatsheets show <name> [section]\n  cheatsheets search <term>\n")
+		return 2
+	}
+	switch args[0] {
+	case "show":
+		if len(args) < 2 || len(args) > 3 {
+			return usage()
+		}
+		section := ""
+		if len(args) == 3 {
+			section = args[2]
+		}
+		return termShow(args[1], section)
+	case "search":
+		if len(args) < 2 {
+			return usage()
+		}
+		return termSearch(strings.Join(args[1:], " "))
+	}
+	return usage()
+}
@@ -13,6 +13,7 @@ var (
 	isWindows                = u.IsWindows
 	openBrowser              = u.OpenBrowser
 	dirExists                = u.DirExists
+	fileExists               = u.FileExists
 	normalizeNewlinesInPlace = u.NormalizeNewlinesInPlace
 	formatSize               = u.FormatSize
 	formatDuration           = u.FormatDuration